@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+const archiveDateFormat = "2006-01-02"
+
+// ArchiveConfig struct
+type ArchiveConfig struct {
+	Dir string `json:"dir"`
+}
+
+// Archive keeps topic items on disk, one JSON file per topic and day
+type Archive struct {
+	sync.Mutex
+	dir    string
+	states map[string]*ItemStates
+	seen   map[string]*ItemSeen
+}
+
+var archive = NewArchive("archive")
+
+// NewArchive creates an archive rooted at dir
+func NewArchive(dir string) *Archive {
+	return &Archive{dir: dir}
+}
+
+func (a *Archive) path(topic string, day string) string {
+	return filepath.Join(a.dir, topic, day+".json")
+}
+
+func (a *Archive) readDay(topic string, day string) (map[string]RssItem, error) {
+	items := make(map[string]RssItem)
+
+	data, readErr := ioutil.ReadFile(a.path(topic, day))
+	if readErr != nil {
+		if os.IsNotExist(readErr) {
+			return items, nil
+		}
+		return nil, readErr
+	}
+
+	if jsonErr := json.Unmarshal(data, &items); jsonErr != nil {
+		return nil, jsonErr
+	}
+
+	return items, nil
+}
+
+func (a *Archive) writeDay(topic string, day string, items map[string]RssItem) error {
+	path := a.path(topic, day)
+	if mkdirErr := os.MkdirAll(filepath.Dir(path), 0755); mkdirErr != nil {
+		return mkdirErr
+	}
+
+	data, jsonErr := json.Marshal(items)
+	if jsonErr != nil {
+		return jsonErr
+	}
+
+	tmp := path + ".tmp"
+	if writeErr := ioutil.WriteFile(tmp, data, 0644); writeErr != nil {
+		return writeErr
+	}
+	return os.Rename(tmp, path)
+}
+
+// archiveDay returns the Taipei calendar day of t
+func archiveDay(t time.Time) string {
+	if location, loadLocationErr := time.LoadLocation(timeZone); loadLocationErr == nil {
+		t = t.In(location)
+	}
+	return t.Format(archiveDateFormat)
+}
+
+// Save merges items into the archive, keyed by their GUID
+func (a *Archive) Save(topic string, news []RssItem) error {
+	byDay := make(map[string][]RssItem)
+	for _, item := range news {
+		day := archiveDay(item.Time)
+		byDay[day] = append(byDay[day], item)
+	}
+
+	a.Lock()
+	defer a.Unlock()
+
+	for day, dayNews := range byDay {
+		items, readErr := a.readDay(topic, day)
+		if readErr != nil {
+			return readErr
+		}
+		for _, item := range dayNews {
+			items[itemID(item)] = item
+		}
+		if writeErr := a.writeDay(topic, day, items); writeErr != nil {
+			return writeErr
+		}
+	}
+
+	return a.markSeen(news)
+}
+
+// Load returns the archived items of a topic between from and to, both
+// days included, newest first
+func (a *Archive) Load(topic string, from time.Time, to time.Time) ([]RssItem, error) {
+	news := []RssItem{}
+
+	a.Lock()
+	defer a.Unlock()
+
+	last := archiveDay(to)
+	for day := from; archiveDay(day) <= last; day = day.AddDate(0, 0, 1) {
+		items, readErr := a.readDay(topic, archiveDay(day))
+		if readErr != nil {
+			return nil, readErr
+		}
+		for _, item := range items {
+			if !item.Time.Before(from) && !item.Time.After(to) {
+				news = append(news, item)
+			}
+		}
+	}
+
+	sort.Sort(ByTime(news))
+
+	return news, nil
+}
@@ -0,0 +1,359 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mmcdole/gofeed"
+)
+
+// API key scopes, admin implies every other scope
+const (
+	ScopeRead   = "read"
+	ScopeWrite  = "write"
+	ScopeExport = "export"
+	ScopeAdmin  = "admin"
+)
+
+const (
+	defaultKeyRate   = 10
+	defaultKeyBurst  = 60
+	bucketIdleTTL    = 10 * time.Minute
+	maxClientBuckets = 10000
+	apiKeyContextKey = "apiKey"
+	scopesContextKey = "apiKeyScopes"
+)
+
+// AuthConfig struct
+type AuthConfig struct {
+	PublicRead   bool           `json:"publicRead"`
+	PublicTopics []string       `json:"publicTopics"`
+	Origins      []string       `json:"origins"`
+	Rate         float64        `json:"rate"`
+	Burst        int            `json:"burst"`
+	Keys         []APIKeyConfig `json:"keys"`
+}
+
+// APIKeyConfig struct
+type APIKeyConfig struct {
+	Name    string   `json:"name"`
+	Key     string   `json:"key"`
+	Scopes  []string `json:"scopes"`
+	Origins []string `json:"origins"`
+	Rate    float64  `json:"rate"`
+	Burst   int      `json:"burst"`
+}
+
+// tokenBucket allows Burst requests at once, refilled at Rate per second
+type tokenBucket struct {
+	tokens float64
+	rate   float64
+	burst  float64
+	last   time.Time
+}
+
+// take spends a token, or returns how long to wait for the next one
+func (b *tokenBucket) take(now time.Time) (bool, time.Duration) {
+	b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	if b.rate <= 0 {
+		return false, time.Hour
+	}
+	return false, time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+}
+
+// Authenticator checks API keys, scopes, origins and rate limits
+type Authenticator struct {
+	sync.Mutex
+	conf    AuthConfig
+	keys    map[string]APIKeyConfig
+	buckets map[string]*tokenBucket
+}
+
+var authenticator = NewAuthenticator(defaultConfig().Auth)
+
+// internalKey lets the server call its own endpoints, such as the filter
+// feeds fetched by the topics
+var internalKey = newInternalKey()
+
+// internalPrefix is the address the server reaches itself at
+var internalPrefix string
+
+func newInternalKey() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+func hashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// NewAuthenticator indexes the configured keys
+func NewAuthenticator(conf AuthConfig) *Authenticator {
+	a := &Authenticator{
+		conf:    conf,
+		keys:    make(map[string]APIKeyConfig),
+		buckets: make(map[string]*tokenBucket),
+	}
+	for _, key := range conf.Keys {
+		if key.Key == "" {
+			continue
+		}
+		if key.Rate <= 0 {
+			key.Rate = defaultKeyRate
+		}
+		if key.Burst <= 0 {
+			key.Burst = defaultKeyBurst
+		}
+		a.keys[hashKey(key.Key)] = key
+	}
+	return a
+}
+
+// CorsOrigins returns every allowed origin for the cors middleware, "*"
+// when none is configured
+func (a *Authenticator) CorsOrigins() string {
+	seen := make(map[string]bool)
+	origins := []string{}
+	for _, origin := range a.conf.Origins {
+		if !seen[origin] {
+			seen[origin] = true
+			origins = append(origins, origin)
+		}
+	}
+	for _, key := range a.conf.Keys {
+		for _, origin := range key.Origins {
+			if !seen[origin] {
+				seen[origin] = true
+				origins = append(origins, origin)
+			}
+		}
+	}
+	if len(origins) == 0 || seen["*"] {
+		return "*"
+	}
+	return strings.Join(origins, ", ")
+}
+
+// requestKey reads a key from the Authorization header, X-API-Key or the
+// api_key query parameter, the last one being the only way for EventSource
+func requestKey(c *gin.Context) string {
+	if authorization := c.Request.Header.Get("Authorization"); strings.HasPrefix(authorization, "Bearer ") {
+		return strings.TrimSpace(strings.TrimPrefix(authorization, "Bearer "))
+	}
+	if key := c.Request.Header.Get("X-API-Key"); key != "" {
+		return key
+	}
+	return c.Query("api_key")
+}
+
+func hasScope(scopes []string, scope string) bool {
+	for _, s := range scopes {
+		if s == scope || s == ScopeAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *Authenticator) publicTopic(topic string) bool {
+	if !a.conf.PublicRead {
+		return false
+	}
+	if len(a.conf.PublicTopics) == 0 {
+		return true
+	}
+	return topic != "" && containsString(a.conf.PublicTopics, topic)
+}
+
+// allow spends a token of the named bucket
+func (a *Authenticator) allow(name string, rate float64, burst int) (bool, time.Duration) {
+	a.Lock()
+	defer a.Unlock()
+
+	now := time.Now()
+	if len(a.buckets) > maxClientBuckets {
+		for key, bucket := range a.buckets {
+			if now.Sub(bucket.last) > bucketIdleTTL {
+				delete(a.buckets, key)
+			}
+		}
+	}
+
+	if burst < 1 {
+		burst = 1
+	}
+	bucket := a.buckets[name]
+	if bucket == nil {
+		bucket = &tokenBucket{tokens: float64(burst), rate: rate, burst: float64(burst), last: now}
+		a.buckets[name] = bucket
+	}
+	return bucket.take(now)
+}
+
+// remoteIP returns the address of the peer, the client supplied
+// X-Forwarded-For and X-Real-Ip headers are not trusted for limits
+func remoteIP(r *http.Request) string {
+	host, _, splitErr := net.SplitHostPort(r.RemoteAddr)
+	if splitErr != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func abortAuth(c *gin.Context, code int, message string) {
+	if code == http.StatusUnauthorized {
+		c.Header("WWW-Authenticate", `Bearer realm="firenews"`)
+	}
+	c.JSON(code, gin.H{
+		"error": message,
+	})
+	c.Abort()
+}
+
+func (a *Authenticator) limit(c *gin.Context, name string, rate float64, burst int) bool {
+	ok, wait := a.allow(name, rate, burst)
+	if !ok {
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		abortAuth(c, http.StatusTooManyRequests, "rate limit exceeded")
+	}
+	return ok
+}
+
+// check authorizes a request for a scope, topic names a public topic
+// that anonymous clients may read
+func (a *Authenticator) check(c *gin.Context, scope string, topic string) {
+	key := requestKey(c)
+
+	if key == "" {
+		if scope != ScopeRead || !a.publicTopic(topic) {
+			abortAuth(c, http.StatusUnauthorized, "an API key is required")
+			return
+		}
+		if a.conf.Rate > 0 {
+			a.limit(c, "ip:"+remoteIP(c.Request), a.conf.Rate, a.conf.Burst)
+		}
+		return
+	}
+
+	if key == internalKey {
+		if scope != ScopeRead {
+			abortAuth(c, http.StatusForbidden, "scope "+scope+" is required")
+		}
+		return
+	}
+
+	apiKey, found := a.keys[hashKey(key)]
+	if !found {
+		abortAuth(c, http.StatusUnauthorized, "invalid API key")
+		return
+	}
+
+	if origin := c.Request.Header.Get("Origin"); origin != "" && len(apiKey.Origins) > 0 &&
+		!containsString(apiKey.Origins, origin) && !containsString(apiKey.Origins, "*") {
+		abortAuth(c, http.StatusForbidden, "origin not allowed for this API key")
+		return
+	}
+
+	if !hasScope(apiKey.Scopes, scope) {
+		abortAuth(c, http.StatusForbidden, "scope "+scope+" is required")
+		return
+	}
+
+	if !a.limit(c, "key:"+hashKey(key), apiKey.Rate, apiKey.Burst) {
+		return
+	}
+
+	c.Set(apiKeyContextKey, apiKey.Name)
+	c.Set(scopesContextKey, apiKey.Scopes)
+}
+
+// Require returns a middleware allowing keys with the scope
+func (a *Authenticator) Require(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		a.check(c, scope, "")
+	}
+}
+
+// Read returns a middleware for reading a topic, which anonymous
+// clients may do when the topic is public. An empty topic is public
+// only when every topic is.
+func (a *Authenticator) Read(topic string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		a.check(c, ScopeRead, topic)
+	}
+}
+
+// ReadParam is Read for routes naming the topic in a path parameter
+func (a *Authenticator) ReadParam(param string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		a.check(c, ScopeRead, c.Param(param))
+	}
+}
+
+// ReadWith is Read for requests naming the topic elsewhere, such as in a
+// form field
+func (a *Authenticator) ReadWith(topic func(c *gin.Context) string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		a.check(c, ScopeRead, topic(c))
+	}
+}
+
+// requestKeyName returns the name of the API key of a request
+func requestKeyName(c *gin.Context) string {
+	if name, found := c.Get(apiKeyContextKey); found {
+		if s, ok := name.(string); ok {
+			return s
+		}
+	}
+	return ""
+}
+
+// requestHasScope reports whether the API key of a request has a scope
+func requestHasScope(c *gin.Context, scope string) bool {
+	if scopes, found := c.Get(scopesContextKey); found {
+		if s, ok := scopes.([]string); ok {
+			return hasScope(s, scope)
+		}
+	}
+	return false
+}
+
+// parseFeedURL fetches a feed, signing requests to the server itself
+func parseFeedURL(parser *gofeed.Parser, url string) (*gofeed.Feed, error) {
+	if internalPrefix == "" || !strings.HasPrefix(url, internalPrefix) {
+		return parser.ParseURL(url)
+	}
+
+	req, reqErr := http.NewRequest("GET", url, nil)
+	if reqErr != nil {
+		return nil, reqErr
+	}
+	req.Header.Set("Authorization", "Bearer "+internalKey)
+
+	resp, doErr := http.DefaultClient.Do(req)
+	if doErr != nil {
+		return nil, doErr
+	}
+	defer resp.Body.Close()
+
+	return parser.Parse(resp.Body)
+}
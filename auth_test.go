@@ -0,0 +1,36 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestAnonymousLimitIgnoresForwardedFor(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	authenticator := NewAuthenticator(AuthConfig{PublicRead: true, Rate: 1, Burst: 2})
+	router := gin.New()
+	router.GET("/news", authenticator.Read(""), func(c *gin.Context) {
+		c.String(http.StatusOK, "ok")
+	})
+
+	codes := []int{}
+	for i := 0; i < 4; i++ {
+		req := httptest.NewRequest("GET", "/news", nil)
+		req.RemoteAddr = "192.0.2.1:" + strconv.Itoa(40000+i)
+		req.Header.Set("X-Forwarded-For", "198.51.100."+strconv.Itoa(i))
+		req.Header.Set("X-Real-Ip", "198.51.100."+strconv.Itoa(i))
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		codes = append(codes, w.Code)
+	}
+
+	if codes[0] != http.StatusOK || codes[1] != http.StatusOK || codes[2] != http.StatusTooManyRequests || codes[3] != http.StatusTooManyRequests {
+		t.Errorf("codes = %v, want 200 200 429 429", codes)
+	}
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	bloggerFeedsURL      = "https://www.blogger.com/feeds/"
+	defaultBloggerResult = 25
+	maxBloggerResults    = 500
+)
+
+var bloggerIDErr = errors.New("id must be a blogger blog id or a blog host name")
+var bloggerLabelErr = errors.New("comments feeds cannot be filtered by label")
+var bloggerPagingErr = errors.New("max-results must be between 1 and 500 and start-index at least 1")
+
+var bloggerBlogID = regexp.MustCompile(`^[0-9]+$`)
+var bloggerHost = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]*[a-z0-9])?(\.[a-z0-9]([a-z0-9-]*[a-z0-9])?)*$`)
+
+// BloggerConfig struct
+type BloggerConfig struct {
+	MaxResults int                       `json:"maxResults"`
+	Watchlists map[string][]BloggerWatch `json:"watchlists"`
+}
+
+// BloggerWatch is a blog watched for a topic, Name is the tag of its
+// items and Include an optional pattern the title must match
+type BloggerWatch struct {
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	Label    string `json:"label"`
+	Comments bool   `json:"comments"`
+	Include  string `json:"include"`
+}
+
+// BloggerQuery selects the posts or comments of a blog
+type BloggerQuery struct {
+	Label      string
+	Comments   bool
+	MaxResults int
+	StartIndex int
+}
+
+// bloggerFeedURL builds the feed url of a blog from its numeric id, its
+// host name, or the name of a blogspot.com blog
+func bloggerFeedURL(id string, query BloggerQuery) (string, error) {
+	id = strings.ToLower(strings.TrimSuffix(strings.TrimSpace(id), "."))
+	if query.Comments && query.Label != "" {
+		return "", bloggerLabelErr
+	}
+
+	kind := "posts"
+	if query.Comments {
+		kind = "comments"
+	}
+
+	var feed string
+	switch {
+	case bloggerBlogID.MatchString(id):
+		feed = bloggerFeedsURL + id + "/" + kind + "/default"
+	case bloggerHost.MatchString(id):
+		if !strings.Contains(id, ".") {
+			id += ".blogspot.com"
+		}
+		feed = "https://" + id + "/feeds/" + kind + "/default"
+	default:
+		return "", bloggerIDErr
+	}
+
+	if query.Label != "" {
+		feed += "/-/" + strings.Replace(url.QueryEscape(query.Label), "+", "%20", -1)
+	}
+
+	params := url.Values{}
+	if query.MaxResults > 0 {
+		params.Set("max-results", strconv.Itoa(query.MaxResults))
+	}
+	if query.StartIndex > 1 {
+		params.Set("start-index", strconv.Itoa(query.StartIndex))
+	}
+	if encoded := params.Encode(); encoded != "" {
+		feed += "?" + encoded
+	}
+
+	return feed, nil
+}
+
+// bloggerFilterURL reads a blog feed through the filter endpoint, which
+// applies the outbound url policy and the include pattern
+func bloggerFilterURL(feed string, include string) string {
+	return internalPrefix + "filter?url=" + url.QueryEscape(feed) + "&include=" + url.QueryEscape(include)
+}
+
+// bloggerTag names the items of a blog after its watch or its known news
+// source, falling back to the id
+func bloggerTag(id string, feed string) string {
+	for _, watches := range config.Blogger.Watchlists {
+		for _, watch := range watches {
+			if watch.ID == id && watch.Name != "" {
+				return watch.Name
+			}
+		}
+	}
+	if u, parseErr := url.Parse(feed); parseErr == nil {
+		if source, found := newsSource[urlHostname(u)]; found {
+			return source
+		}
+	}
+	return id
+}
+
+// BloggerFeed returns the posts or comments of a blog matching include,
+// along with the number of entries the page held before filtering
+func BloggerFeed(tag string, id string, query BloggerQuery, include string) ([]RssItem, int, error) {
+	feed, feedErr := bloggerFeedURL(id, query)
+	if feedErr != nil {
+		return nil, 0, feedErr
+	}
+	rp, regexpErr := regexp.Compile(include)
+	if regexpErr != nil {
+		return nil, 0, regexpErr
+	}
+	if tag == "" {
+		tag = bloggerTag(id, feed)
+	}
+
+	entries, fetchErr := loadRSS(tag, bloggerFilterURL(feed, ""))
+	if fetchErr != nil {
+		log.Printf("BloggerFeed loadRSS error: %v", fetchErr)
+	}
+
+	found := []RssItem{}
+	for _, item := range entries {
+		if rp.MatchString(CJKnorm(item.Title + item.Description)) {
+			found = append(found, item)
+		}
+	}
+
+	return cleanNews(found, false), len(entries), nil
+}
+
+// BloggerTimeline reads every blog watched for a topic
+func BloggerTimeline(topic string) []RssItem {
+	maxResults := config.Blogger.MaxResults
+	if maxResults <= 0 {
+		maxResults = defaultBloggerResult
+	}
+
+	feeds := map[string]string{}
+	for _, watch := range config.Blogger.Watchlists[topic] {
+		feed, feedErr := bloggerFeedURL(watch.ID, BloggerQuery{
+			Label:      watch.Label,
+			Comments:   watch.Comments,
+			MaxResults: maxResults,
+		})
+		if feedErr != nil {
+			continue
+		}
+		tag := watch.Name
+		if tag == "" {
+			tag = bloggerTag(watch.ID, feed)
+		}
+		feeds[tag] = bloggerFilterURL(feed, watch.Include)
+	}
+
+	// watch-lists are read on request, only topics subscribe to hubs
+	return newsFetcher("", feeds, false)
+}
+
+func bloggerFeedHandler(c *gin.Context) {
+	query := BloggerQuery{
+		Label:      c.Query("label"),
+		MaxResults: config.Blogger.MaxResults,
+		StartIndex: 1,
+	}
+	if query.MaxResults <= 0 {
+		query.MaxResults = defaultBloggerResult
+	}
+	switch c.Query("comments") {
+	case "", "0", "false":
+	default:
+		query.Comments = true
+	}
+
+	var pagingErr error
+	if text := c.Query("max-results"); text != "" {
+		query.MaxResults, pagingErr = strconv.Atoi(text)
+	}
+	if text := c.Query("start-index"); text != "" && pagingErr == nil {
+		query.StartIndex, pagingErr = strconv.Atoi(text)
+	}
+	if pagingErr != nil || query.MaxResults < 1 || query.MaxResults > maxBloggerResults || query.StartIndex < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": bloggerPagingErr.Error(),
+		})
+		return
+	}
+
+	include := c.Query("include")
+	if _, regexpErr := regexp.Compile(include); regexpErr != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "include is not a valid pattern",
+		})
+		return
+	}
+
+	news, entries, feedErr := BloggerFeed(c.Query("tag"), c.Param("id"), query, include)
+	if feedErr != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": feedErr.Error(),
+		})
+		return
+	}
+
+	// a full page of entries upstream means there may be more, however
+	// few of them matched include
+	next := ""
+	if entries >= query.MaxResults {
+		next = strconv.Itoa(query.StartIndex + query.MaxResults)
+	}
+	c.JSON(200, gin.H{
+		"news":           news,
+		"nextStartIndex": next,
+	})
+}
+
+func bloggerTopicHandler(c *gin.Context) {
+	topic := c.Param("topic")
+	if _, found := config.Blogger.Watchlists[topic]; !found {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "no blogger watch-list for " + topic,
+		})
+		return
+	}
+
+	c.JSON(200, gin.H{
+		"news": BloggerTimeline(topic),
+	})
+}
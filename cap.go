@@ -0,0 +1,357 @@
+package main
+
+import (
+	"encoding/xml"
+	"hash/fnv"
+	"log"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mmcdole/gofeed"
+)
+
+const earthRadiusKm = 6371.0
+
+// Area struct
+type Area struct {
+	AreaDesc string      `xml:"areaDesc"`
+	Polygon  []string    `xml:"polygon"`
+	Circle   []string    `xml:"circle"`
+	Geocode  []Parameter `xml:"geocode"`
+}
+
+// Point is a [latitude, longitude] pair
+type Point [2]float64
+
+// Jurisdiction describes the area we are responsible for
+type Jurisdiction struct {
+	Name     string   `json:"name"`
+	Points   []Point  `json:"points"`
+	Polygon  []Point  `json:"polygon"`
+	Geocodes []string `json:"geocodes"`
+}
+
+// IsEmpty reports whether no area has been configured
+func (j Jurisdiction) IsEmpty() bool {
+	return len(j.Points) == 0 && len(j.Polygon) == 0 && len(j.Geocodes) == 0
+}
+
+// Covers reports whether a CAP area overlaps the jurisdiction, either by
+// geocode membership, by containing one of our points or by intersecting
+// our polygon
+func (j Jurisdiction) Covers(area Area) bool {
+	for _, geocode := range area.Geocode {
+		for _, code := range j.Geocodes {
+			if code != "" && strings.HasPrefix(strings.TrimSpace(geocode.Value), code) {
+				return true
+			}
+		}
+	}
+
+	for _, text := range area.Polygon {
+		polygon := parseCapPolygon(text)
+		if len(polygon) < 3 {
+			continue
+		}
+		for _, pt := range j.Points {
+			if pointInPolygon(pt, polygon) {
+				return true
+			}
+		}
+		if len(j.Polygon) >= 3 && polygonsIntersect(polygon, j.Polygon) {
+			return true
+		}
+	}
+
+	for _, text := range area.Circle {
+		center, radius, ok := parseCapCircle(text)
+		if !ok {
+			continue
+		}
+		for _, pt := range j.Points {
+			if distanceKm(center, pt) <= radius {
+				return true
+			}
+		}
+		if len(j.Polygon) >= 3 {
+			if pointInPolygon(center, j.Polygon) {
+				return true
+			}
+			for i := range j.Polygon {
+				if segmentDistanceKm(center, j.Polygon[i], j.Polygon[(i+1)%len(j.Polygon)]) <= radius {
+					return true
+				}
+			}
+		}
+	}
+
+	return false
+}
+
+// MatchAreas returns the descriptions of the CAP areas covering the
+// jurisdiction, an empty jurisdiction matches every area
+func (j Jurisdiction) MatchAreas(areas []Area) []string {
+	var matched []string
+	for _, area := range areas {
+		if j.IsEmpty() || j.Covers(area) {
+			matched = append(matched, area.AreaDesc)
+		}
+	}
+	return matched
+}
+
+// parseCapPolygon parses "lat,lon lat,lon ..." pairs
+func parseCapPolygon(text string) []Point {
+	var polygon []Point
+	for _, pair := range strings.Fields(text) {
+		pt, ok := parseCapPoint(pair)
+		if ok {
+			polygon = append(polygon, pt)
+		}
+	}
+	return polygon
+}
+
+// parseCapCircle parses "lat,lon radius" where radius is in kilometers
+func parseCapCircle(text string) (Point, float64, bool) {
+	fields := strings.Fields(text)
+	if len(fields) != 2 {
+		return Point{}, 0, false
+	}
+	center, ok := parseCapPoint(fields[0])
+	if !ok {
+		return Point{}, 0, false
+	}
+	radius, parseErr := strconv.ParseFloat(fields[1], 64)
+	if parseErr != nil {
+		return Point{}, 0, false
+	}
+	return center, radius, true
+}
+
+func parseCapPoint(pair string) (Point, bool) {
+	latlon := strings.Split(pair, ",")
+	if len(latlon) != 2 {
+		return Point{}, false
+	}
+	lat, latErr := strconv.ParseFloat(latlon[0], 64)
+	lon, lonErr := strconv.ParseFloat(latlon[1], 64)
+	if latErr != nil || lonErr != nil {
+		return Point{}, false
+	}
+	return Point{lat, lon}, true
+}
+
+// pointInPolygon uses ray casting, which is fine at county scale
+func pointInPolygon(pt Point, polygon []Point) bool {
+	inside := false
+	for i, j := 0, len(polygon)-1; i < len(polygon); j, i = i, i+1 {
+		pi, pj := polygon[i], polygon[j]
+		if (pi[1] > pt[1]) != (pj[1] > pt[1]) &&
+			pt[0] < (pj[0]-pi[0])*(pt[1]-pi[1])/(pj[1]-pi[1])+pi[0] {
+			inside = !inside
+		}
+	}
+	return inside
+}
+
+func polygonsIntersect(a []Point, b []Point) bool {
+	for _, pt := range a {
+		if pointInPolygon(pt, b) {
+			return true
+		}
+	}
+	for _, pt := range b {
+		if pointInPolygon(pt, a) {
+			return true
+		}
+	}
+	for i := range a {
+		a1, a2 := a[i], a[(i+1)%len(a)]
+		for j := range b {
+			if segmentsIntersect(a1, a2, b[j], b[(j+1)%len(b)]) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func segmentsIntersect(p1 Point, p2 Point, q1 Point, q2 Point) bool {
+	d1 := cross(q1, q2, p1)
+	d2 := cross(q1, q2, p2)
+	d3 := cross(p1, p2, q1)
+	d4 := cross(p1, p2, q2)
+	return ((d1 > 0 && d2 < 0) || (d1 < 0 && d2 > 0)) &&
+		((d3 > 0 && d4 < 0) || (d3 < 0 && d4 > 0))
+}
+
+func cross(o Point, a Point, b Point) float64 {
+	return (a[0]-o[0])*(b[1]-o[1]) - (a[1]-o[1])*(b[0]-o[0])
+}
+
+// distanceKm returns the great-circle distance between two points
+func distanceKm(a Point, b Point) float64 {
+	lat1 := a[0] * math.Pi / 180
+	lat2 := b[0] * math.Pi / 180
+	dLat := lat2 - lat1
+	dLon := (b[1] - a[1]) * math.Pi / 180
+
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadiusKm * math.Asin(math.Sqrt(h))
+}
+
+// segmentDistanceKm returns the distance from a point to a segment, on a
+// plane tangent at the point, which is fine at county scale
+func segmentDistanceKm(pt Point, a Point, b Point) float64 {
+	kmPerDegree := earthRadiusKm * math.Pi / 180
+	cosLat := math.Cos(pt[0] * math.Pi / 180)
+	ax, ay := (a[1]-pt[1])*cosLat*kmPerDegree, (a[0]-pt[0])*kmPerDegree
+	bx, by := (b[1]-pt[1])*cosLat*kmPerDegree, (b[0]-pt[0])*kmPerDegree
+
+	dx, dy := bx-ax, by-ay
+	t := 0.0
+	if length := dx*dx + dy*dy; length > 0 {
+		t = math.Max(0, math.Min(1, -(ax*dx+ay*dy)/length))
+	}
+	return math.Hypot(ax+t*dx, ay+t*dy)
+}
+
+func capTitle(v ResultCap) string {
+	if len(v.Resource) > 1 {
+		return v.Resource[1].URI
+	}
+	return v.Headline
+}
+
+// capEntry is a feed item together with the CAP document it links to
+type capEntry struct {
+	Item *gofeed.Item
+	Cap  ResultCap
+}
+
+// fetchCapEntries loads the CAP documents linked from an atom feed, it
+// fails when the feed itself cannot be loaded
+func fetchCapEntries(url string) ([]capEntry, error) {
+	collect := []capEntry{}
+	parser := gofeed.NewParser()
+
+	data, fetchErr := fetchReport(url)
+	if fetchErr != nil {
+		return collect, fetchErr
+	}
+	local := !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://")
+
+	feed, parserErr := parser.ParseString(string(data))
+	if parserErr != nil {
+		return collect, parserErr
+	}
+
+	wgEntries := make(chan capEntry)
+	var wg sync.WaitGroup
+	wg.Add(len(feed.Items))
+
+	for _, item := range feed.Items {
+		go func(item *gofeed.Item) {
+			defer wg.Done()
+
+			// only local fixtures may link to local files, links of a
+			// remote feed go through the outbound url policy
+			var xmldata []byte
+			var fetchErr error
+			if local {
+				xmldata, fetchErr = fetchReport(item.Link)
+			} else {
+				xmldata, fetchErr = egress.Fetch(item.Link, time.Second*10)
+			}
+			if fetchErr != nil {
+				log.Printf("fetchCapEntries fetch error: %v", fetchErr)
+				return
+			}
+
+			v := ResultCap{}
+			if xmlErr := xml.Unmarshal(xmldata, &v); xmlErr != nil {
+				log.Printf("fetchCapEntries xml.Unmarshal error: %v", xmlErr)
+				return
+			}
+
+			wgEntries <- capEntry{Item: item, Cap: v}
+		}(item)
+	}
+
+	go func() {
+		wg.Wait()
+		close(wgEntries)
+	}()
+
+	for entry := range wgEntries {
+		collect = append(collect, entry)
+	}
+
+	return collect, nil
+}
+
+// LoadCAP loads CAP alerts linked from an atom feed and keeps the ones
+// covering the jurisdiction
+func LoadCAP(tag string, url string, j Jurisdiction) ([]RssItem, error) {
+	collect := []RssItem{}
+
+	entries, fetchErr := fetchCapEntries(url)
+	if fetchErr != nil {
+		return collect, fetchErr
+	}
+
+	for _, entry := range entries {
+		v := entry.Cap
+		areas := j.MatchAreas(v.Area)
+		if len(areas) == 0 {
+			continue
+		}
+
+		timetext := v.Sent
+		if timetext == "" {
+			timetext = entry.Item.Published
+		}
+		local := loadLocal(timetext, "")
+		title := capTitle(v)
+
+		h := fnv.New32a()
+		h.Write([]byte(title))
+
+		source, keyword := GetNewsSource(v.Web)
+
+		collect = append(collect, RssItem{
+			Link:        v.Web,
+			OriginLink:  v.Web,
+			Time:        local,
+			TimeText:    local.Format("15:04"),
+			Title:       title,
+			Source:      source,
+			Tag:         tag,
+			Status:      1,
+			Hash:        h.Sum32(),
+			Keyword:     keyword,
+			Description: entry.Item.Description,
+			Area:        strings.Join(areas, "、"),
+		})
+	}
+
+	return collect, nil
+}
+
+func capFetcher(feeds map[string]string, j Jurisdiction) []RssItem {
+	sources := []Source{}
+	for tag, url := range feeds {
+		sources = append(sources, &CAPSource{Tag: tag, URL: url, Jurisdiction: j})
+	}
+
+	news := fetchTopicSources("", sources)
+	sort.Sort(ByTime(news))
+
+	return news
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+)
+
+const defaultConfigFile = "firenews.json"
+
+// Config struct
+type Config struct {
+	Jurisdiction Jurisdiction              `json:"jurisdiction"`
+	Earthquake   EarthquakeConfig          `json:"earthquake"`
+	Typhoon      TyphoonConfig             `json:"typhoon"`
+	Archive      ArchiveConfig             `json:"archive"`
+	Stream       StreamConfig              `json:"stream"`
+	Webhooks     []WebhookConfig           `json:"webhooks"`
+	Digest       DigestConfig              `json:"digest"`
+	Auth         AuthConfig                `json:"auth"`
+	Egress       EgressConfig              `json:"egress"`
+	Credentials  CredentialsConfig         `json:"credentials"`
+	Facebook     FacebookConfig            `json:"facebook"`
+	Blogger      BloggerConfig             `json:"blogger"`
+	Sources      map[string][]SourceConfig `json:"sources"`
+	WebSub       WebSubConfig              `json:"websub"`
+	Timelines    map[string]TimelineConfig `json:"timelines"`
+}
+
+var config = defaultConfig()
+
+func defaultConfig() Config {
+	return Config{
+		Jurisdiction: Jurisdiction{
+			Name:     "新竹市",
+			Geocodes: []string{"10018"},
+		},
+		Earthquake: EarthquakeConfig{
+			URL:          "https://opendata.cwb.gov.tw/api/v1/rest/datastore/E-A0015-001?format=JSON",
+			County:       "新竹市",
+			MinMagnitude: 0,
+			MinIntensity: "1級",
+		},
+		Typhoon: TyphoonConfig{
+			URL:            "https://alerts.ncdr.nat.gov.tw/RssAtomFeed.ashx?AlertType=5",
+			IdleInterval:   600,
+			ActiveInterval: 60,
+		},
+		Archive: ArchiveConfig{
+			Dir: "archive",
+		},
+		Stream: StreamConfig{
+			PollInterval: 300,
+			Heartbeat:    15,
+		},
+		Auth: AuthConfig{
+			PublicRead: true,
+			Rate:       2,
+			Burst:      30,
+		},
+		Egress: EgressConfig{
+			Schemes:      []string{"http", "https"},
+			MaxBytes:     5 << 20,
+			MaxRedirects: 5,
+		},
+		Credentials: CredentialsConfig{
+			SecretsFile:   "/run/secrets/firenews",
+			EncryptedFile: "secrets.enc",
+			KeyEnv:        defaultSecretsKeyEnv,
+		},
+		Facebook: FacebookConfig{
+			Version:  "v21.0",
+			MaxPages: 5,
+			MaxPosts: 200,
+			MaxAge:   72,
+			Timeout:  30,
+			CacheTTL: 300,
+		},
+		Timelines: map[string]TimelineConfig{
+			"hcfd": {
+				News:     []string{"hcfd"},
+				CAP:      []string{"ncdr"},
+				Facebook: "hcfd",
+				Blogger:  "hcfd",
+			},
+		},
+		Blogger: BloggerConfig{
+			MaxResults: 25,
+			Watchlists: map[string][]BloggerWatch{
+				"hcfd": {
+					{ID: "hcfdrss.blogspot.com", Name: "爆料公社"},
+				},
+			},
+		},
+		Digest: DigestConfig{
+			Topics:  []string{"main", "city", "hcfd"},
+			Times:   []string{"08:00", "20:00"},
+			Subject: "新聞摘要",
+			SMTP: SMTPConfig{
+				Port: 25,
+			},
+		},
+	}
+}
+
+// LoadConfig reads the JSON config file named by FIRENEWS_CONFIG,
+// falling back to firenews.json and then to the built-in defaults
+func LoadConfig() Config {
+	conf := defaultConfig()
+
+	path := os.Getenv("FIRENEWS_CONFIG")
+	if path == "" {
+		path = defaultConfigFile
+	}
+
+	data, readErr := ioutil.ReadFile(path)
+	if readErr != nil {
+		if !os.IsNotExist(readErr) {
+			log.Printf("LoadConfig ioutil.ReadFile error: %v", readErr)
+		}
+		return conf
+	}
+
+	if jsonErr := json.Unmarshal(data, &conf); jsonErr != nil {
+		log.Printf("LoadConfig json.Unmarshal error: %v", jsonErr)
+		return defaultConfig()
+	}
+
+	return conf
+}
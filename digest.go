@@ -0,0 +1,422 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	htmltemplate "html/template"
+	"io/ioutil"
+	"log"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+	"unicode"
+)
+
+// eventSimilarity is the bigram overlap above which two titles are taken
+// to report the same event
+const eventSimilarity = 0.5
+
+// DigestConfig struct
+type DigestConfig struct {
+	Topics    []string   `json:"topics"`
+	Times     []string   `json:"times"`
+	From      string     `json:"from"`
+	To        []string   `json:"to"`
+	Subject   string     `json:"subject"`
+	DryRunDir string     `json:"dryRunDir"`
+	SMTP      SMTPConfig `json:"smtp"`
+}
+
+// SMTPConfig struct
+type SMTPConfig struct {
+	Host     string `json:"host"`
+	Port     int    `json:"port"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// DigestEvent is a group of items reporting the same story
+type DigestEvent struct {
+	Title string
+	Time  time.Time
+	Items []RssItem
+}
+
+// DigestSourceCount struct
+type DigestSourceCount struct {
+	Source string
+	Count  int
+}
+
+// DigestTopic struct
+type DigestTopic struct {
+	Name    string
+	Total   int
+	Events  []DigestEvent
+	Sources []DigestSourceCount
+}
+
+// Digest struct
+type Digest struct {
+	Subject string
+	From    time.Time
+	To      time.Time
+	Topics  []DigestTopic
+}
+
+var digestTextTemplate = template.Must(template.New("digest").Parse(`{{.Subject}}
+{{.From.Format "2006/01/02 15:04"}} - {{.To.Format "2006/01/02 15:04"}}
+{{range .Topics}}
+== {{.Name}} ({{.Total}}) ==
+{{range .Events}}
+* {{.Title}}
+{{range .Items}}  - {{.TimeText}} {{.Source}} {{.Title}}
+    {{.OriginLink}}
+{{end}}{{end}}
+{{range .Sources}}{{.Source}}: {{.Count}}  {{end}}
+{{end}}`))
+
+var digestHTMLTemplate = htmltemplate.Must(htmltemplate.New("digest").Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>{{.Subject}}</title></head>
+<body>
+<h1>{{.Subject}}</h1>
+<p>{{.From.Format "2006/01/02 15:04"}} - {{.To.Format "2006/01/02 15:04"}}</p>
+{{range .Topics}}
+<h2>{{.Name}} ({{.Total}})</h2>
+{{range .Events}}
+<h3>{{.Title}}</h3>
+<ul>
+{{range .Items}}<li>{{.TimeText}} {{.Source}} <a href="{{.OriginLink}}">{{.Title}}</a></li>
+{{end}}</ul>
+{{end}}
+<table>
+{{range .Sources}}<tr><td>{{.Source}}</td><td>{{.Count}}</td></tr>
+{{end}}</table>
+{{end}}
+</body></html>
+`))
+
+// titleBigrams returns the character bigrams of a title without spaces
+// and punctuation
+func titleBigrams(title string) map[string]bool {
+	var runes []rune
+	for _, r := range CJKnorm(title) {
+		if unicode.IsLetter(r) || unicode.IsNumber(r) {
+			runes = append(runes, r)
+		}
+	}
+
+	bigrams := make(map[string]bool)
+	for i := 0; i+1 < len(runes); i++ {
+		bigrams[string(runes[i:i+2])] = true
+	}
+	return bigrams
+}
+
+func bigramSimilarity(a map[string]bool, b map[string]bool) float64 {
+	if len(a) == 0 || len(b) == 0 {
+		return 0
+	}
+	common := 0
+	for k := range a {
+		if b[k] {
+			common++
+		}
+	}
+	smaller := len(a)
+	if len(b) < smaller {
+		smaller = len(b)
+	}
+	return float64(common) / float64(smaller)
+}
+
+// GroupEvents clusters items whose titles overlap, oldest item first
+func GroupEvents(news []RssItem) []DigestEvent {
+	sorted := make([]RssItem, len(news))
+	copy(sorted, news)
+	sort.Sort(sort.Reverse(ByTime(sorted)))
+
+	var groups []DigestEvent
+	var grams []map[string]bool
+	for _, item := range sorted {
+		bigrams := titleBigrams(item.Title)
+		matched := -1
+		for i := range groups {
+			if bigramSimilarity(bigrams, grams[i]) >= eventSimilarity {
+				matched = i
+				break
+			}
+		}
+		if matched < 0 {
+			groups = append(groups, DigestEvent{Title: item.Title, Time: item.Time})
+			grams = append(grams, bigrams)
+			matched = len(groups) - 1
+		}
+		groups[matched].Items = append(groups[matched].Items, item)
+		if item.Time.After(groups[matched].Time) {
+			groups[matched].Time = item.Time
+		}
+	}
+
+	// newest event first
+	for i, j := 0, len(groups)-1; i < j; i, j = i+1, j-1 {
+		groups[i], groups[j] = groups[j], groups[i]
+	}
+	return groups
+}
+
+// countSources counts items per source, most frequent first
+func countSources(news []RssItem) []DigestSourceCount {
+	counts := make(map[string]int)
+	for _, item := range news {
+		counts[item.Source]++
+	}
+
+	collect := []DigestSourceCount{}
+	for source, count := range counts {
+		collect = append(collect, DigestSourceCount{Source: source, Count: count})
+	}
+	sort.Sort(bySourceCount(collect))
+	return collect
+}
+
+type bySourceCount []DigestSourceCount
+
+func (a bySourceCount) Len() int      { return len(a) }
+func (a bySourceCount) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a bySourceCount) Less(i, j int) bool {
+	if a[i].Count != a[j].Count {
+		return a[i].Count > a[j].Count
+	}
+	return a[i].Source < a[j].Source
+}
+
+// BuildDigest collects the archived items of each topic between from and
+// to, using the current snapshot when the archive cannot be read
+func BuildDigest(topics *TopicRegistry, conf DigestConfig, from time.Time, to time.Time) Digest {
+	digest := Digest{
+		Subject: conf.Subject,
+		From:    from,
+		To:      to,
+	}
+
+	for _, name := range conf.Topics {
+		topic := topics.Get(name)
+		if topic == nil {
+			continue
+		}
+
+		news, loadErr := archive.Load(name, from, to)
+		if loadErr != nil {
+			log.Printf("BuildDigest archive.Load error: %v", loadErr)
+			news = []RssItem{}
+			for _, item := range topic.Current().Result.News {
+				if !item.Time.Before(from) && !item.Time.After(to) {
+					news = append(news, item)
+				}
+			}
+		}
+
+		digest.Topics = append(digest.Topics, DigestTopic{
+			Name:    name,
+			Total:   len(news),
+			Events:  GroupEvents(news),
+			Sources: countSources(news),
+		})
+	}
+
+	return digest
+}
+
+// RenderDigest renders the plain text and HTML bodies
+func RenderDigest(digest Digest) (string, string, error) {
+	var text, html bytes.Buffer
+	if err := digestTextTemplate.Execute(&text, digest); err != nil {
+		return "", "", err
+	}
+	if err := digestHTMLTemplate.Execute(&html, digest); err != nil {
+		return "", "", err
+	}
+	return text.String(), html.String(), nil
+}
+
+func writeQuotedPart(w *multipart.Writer, contentType string, body string) error {
+	header := textproto.MIMEHeader{}
+	header.Set("Content-Type", contentType)
+	header.Set("Content-Transfer-Encoding", "quoted-printable")
+
+	part, partErr := w.CreatePart(header)
+	if partErr != nil {
+		return partErr
+	}
+	qp := quotedprintable.NewWriter(part)
+	if _, writeErr := qp.Write([]byte(body)); writeErr != nil {
+		return writeErr
+	}
+	return qp.Close()
+}
+
+// DigestMessage builds a multipart/alternative message
+func DigestMessage(conf DigestConfig, digest Digest) ([]byte, error) {
+	text, html, renderErr := RenderDigest(digest)
+	if renderErr != nil {
+		return nil, renderErr
+	}
+
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	if err := writeQuotedPart(w, "text/plain; charset=utf-8", text); err != nil {
+		return nil, err
+	}
+	if err := writeQuotedPart(w, "text/html; charset=utf-8", html); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	var msg bytes.Buffer
+	msg.WriteString("From: " + conf.From + "\r\n")
+	msg.WriteString("To: " + strings.Join(conf.To, ", ") + "\r\n")
+	msg.WriteString("Subject: " + mime.QEncoding.Encode("utf-8", digest.Subject) + "\r\n")
+	msg.WriteString("Date: " + digest.To.Format(time.RFC1123Z) + "\r\n")
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: multipart/alternative; boundary=" + w.Boundary() + "\r\n")
+	msg.WriteString("\r\n")
+	msg.Write(body.Bytes())
+
+	return msg.Bytes(), nil
+}
+
+// SendDigest sends the digest over SMTP, or writes it as an .eml file
+// into DryRunDir when that is set
+func SendDigest(conf DigestConfig, digest Digest) (string, error) {
+	msg, msgErr := DigestMessage(conf, digest)
+	if msgErr != nil {
+		return "", msgErr
+	}
+
+	if conf.DryRunDir != "" {
+		if mkdirErr := os.MkdirAll(conf.DryRunDir, 0755); mkdirErr != nil {
+			return "", mkdirErr
+		}
+		path := filepath.Join(conf.DryRunDir, "digest-"+digest.To.Format("20060102-1504")+".eml")
+		return path, ioutil.WriteFile(path, msg, 0644)
+	}
+
+	if conf.SMTP.Host == "" || len(conf.To) == 0 {
+		return "", errors.New("SendDigest: smtp host and recipients are required")
+	}
+
+	port := conf.SMTP.Port
+	if port == 0 {
+		port = 25
+	}
+	addr := net.JoinHostPort(conf.SMTP.Host, strconv.Itoa(port))
+
+	var auth smtp.Auth
+	if conf.SMTP.Username != "" {
+		auth = smtp.PlainAuth("", conf.SMTP.Username, conf.SMTP.Password, conf.SMTP.Host)
+	}
+
+	return addr, smtp.SendMail(addr, auth, conf.From, conf.To, msg)
+}
+
+// digestSlots returns the scheduled times of the day around now
+func digestSlots(conf DigestConfig, now time.Time) []time.Time {
+	var slots []time.Time
+	for _, day := range []int{-1, 0, 1} {
+		base := now.AddDate(0, 0, day)
+		for _, clock := range conf.Times {
+			t, parseErr := time.Parse("15:04", clock)
+			if parseErr != nil {
+				log.Printf("digestSlots time.Parse error: %v", parseErr)
+				continue
+			}
+			slots = append(slots, time.Date(base.Year(), base.Month(), base.Day(), t.Hour(), t.Minute(), 0, 0, now.Location()))
+		}
+	}
+	sort.Sort(byTimeAsc(slots))
+	return slots
+}
+
+type byTimeAsc []time.Time
+
+func (a byTimeAsc) Len() int           { return len(a) }
+func (a byTimeAsc) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a byTimeAsc) Less(i, j int) bool { return a[i].Before(a[j]) }
+
+// digestWindow returns the shift ending at the latest slot not after now,
+// and the next slot
+func digestWindow(conf DigestConfig, now time.Time) (time.Time, time.Time, time.Time) {
+	slots := digestSlots(conf, now)
+	var prev, last, next time.Time
+	for _, slot := range slots {
+		if !slot.After(now) {
+			prev, last = last, slot
+		} else if next.IsZero() {
+			next = slot
+		}
+	}
+	return prev, last, next
+}
+
+// digestShift returns the last shift before now, or the last twelve
+// hours when no schedule is configured
+func digestShift(conf DigestConfig, now time.Time) (time.Time, time.Time) {
+	from, to, _ := digestWindow(conf, now)
+	if to.IsZero() || from.IsZero() {
+		return now.Add(-12 * time.Hour), now
+	}
+	return from, to
+}
+
+// RunDigest builds and sends the digest for the shift ending at now
+func RunDigest(topics *TopicRegistry, conf DigestConfig, now time.Time) (string, error) {
+	from, to := digestShift(conf, now)
+	return SendDigest(conf, BuildDigest(topics, conf, from, to))
+}
+
+// StartDigest sends digests at the configured times of day, it only runs
+// when there is somewhere to send them
+func StartDigest(topics *TopicRegistry, conf DigestConfig) {
+	if len(conf.Times) == 0 || len(conf.Topics) == 0 {
+		return
+	}
+	mailing := conf.SMTP.Host != "" && conf.From != "" && len(conf.To) > 0
+	if !mailing && conf.DryRunDir == "" {
+		return
+	}
+
+	location, loadLocationErr := time.LoadLocation(timeZone)
+	if loadLocationErr != nil {
+		location = time.Local
+	}
+
+	go func() {
+		for {
+			_, _, next := digestWindow(conf, time.Now().In(location))
+			if next.IsZero() {
+				return
+			}
+			time.Sleep(next.Sub(time.Now()))
+
+			dest, sendErr := RunDigest(topics, conf, next)
+			if sendErr != nil {
+				log.Printf("StartDigest SendDigest error: %v", sendErr)
+				continue
+			}
+			log.Println("digest sent:", dest)
+		}
+	}()
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const earthquakeNewsWindow = 6 * time.Hour
+
+// EarthquakeConfig struct
+type EarthquakeConfig struct {
+	URL          string  `json:"url"`
+	County       string  `json:"county"`
+	MinMagnitude float64 `json:"minMagnitude"`
+	MinIntensity string  `json:"minIntensity"`
+}
+
+// EarthquakeReport struct
+type EarthquakeReport struct {
+	No             string            `json:"no"`
+	Time           time.Time         `json:"time"`
+	TimeText       string            `json:"timeText"`
+	Epicenter      string            `json:"epicenter"`
+	Latitude       float64           `json:"latitude"`
+	Longitude      float64           `json:"longitude"`
+	Depth          float64           `json:"depth"`
+	Magnitude      float64           `json:"magnitude"`
+	Intensity      map[string]string `json:"intensity"`
+	LocalIntensity string            `json:"localIntensity"`
+	Content        string            `json:"content"`
+	Link           string            `json:"link"`
+	News           []RssItem         `json:"news"`
+}
+
+// ByOriginTime implements sort.Interface for []EarthquakeReport based on
+// the Time field.
+type ByOriginTime []EarthquakeReport
+
+func (a ByOriginTime) Len() int           { return len(a) }
+func (a ByOriginTime) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a ByOriginTime) Less(i, j int) bool { return a[i].Time.After(a[j].Time) }
+
+// flexString accepts both json strings and numbers
+type flexString string
+
+func (s *flexString) UnmarshalJSON(data []byte) error {
+	if len(data) > 0 && data[0] == '"' {
+		var str string
+		if err := json.Unmarshal(data, &str); err != nil {
+			return err
+		}
+		*s = flexString(str)
+		return nil
+	}
+	*s = flexString(bytes.Trim(data, " "))
+	return nil
+}
+
+func (s flexString) Float() float64 {
+	f, _ := strconv.ParseFloat(strings.TrimSpace(string(s)), 64)
+	return f
+}
+
+type cwbValue struct {
+	Value flexString `json:"value" xml:"value"`
+	Unit  string     `json:"unit" xml:"unit"`
+}
+
+type cwbShakingArea struct {
+	AreaDesc      string   `json:"areaDesc" xml:"areaDesc"`
+	AreaName      string   `json:"areaName" xml:"areaName"`
+	AreaIntensity cwbValue `json:"areaIntensity" xml:"areaIntensity"`
+}
+
+type cwbEarthquake struct {
+	EarthquakeNo   flexString `json:"earthquakeNo" xml:"earthquakeNo"`
+	ReportContent  string     `json:"reportContent" xml:"reportContent"`
+	Web            string     `json:"web" xml:"web"`
+	EarthquakeInfo struct {
+		OriginTime string   `json:"originTime" xml:"originTime"`
+		Depth      cwbValue `json:"depth" xml:"depth"`
+		EpiCenter  struct {
+			Location     string   `json:"location" xml:"location"`
+			EpiCenterLat cwbValue `json:"epiCenterLat" xml:"epiCenterLat"`
+			EpiCenterLon cwbValue `json:"epiCenterLon" xml:"epiCenterLon"`
+		} `json:"epiCenter" xml:"epiCenter"`
+		Magnitude struct {
+			MagnitudeType  string     `json:"magnitudeType" xml:"magnitudeType"`
+			MagnitudeValue flexString `json:"magnitudeValue" xml:"magnitudeValue"`
+		} `json:"magnitude" xml:"magnitude"`
+	} `json:"earthquakeInfo" xml:"earthquakeInfo"`
+	Intensity struct {
+		ShakingArea []cwbShakingArea `json:"shakingArea" xml:"shakingArea"`
+	} `json:"intensity" xml:"intensity"`
+}
+
+type cwbEarthquakeJSON struct {
+	Records struct {
+		Earthquake []cwbEarthquake `json:"earthquake"`
+	} `json:"records"`
+}
+
+type cwbEarthquakeXML struct {
+	Earthquake []cwbEarthquake `xml:"dataset>earthquake"`
+}
+
+// ParseEarthquakeReports parses a CWB earthquake report in JSON or XML
+func ParseEarthquakeReports(data []byte) ([]EarthquakeReport, error) {
+	var quakes []cwbEarthquake
+
+	trimmed := bytes.TrimSpace(data)
+	if bytes.HasPrefix(trimmed, []byte("{")) {
+		v := cwbEarthquakeJSON{}
+		if jsonErr := json.Unmarshal(trimmed, &v); jsonErr != nil {
+			return nil, jsonErr
+		}
+		quakes = v.Records.Earthquake
+	} else {
+		v := cwbEarthquakeXML{}
+		if xmlErr := xml.Unmarshal(trimmed, &v); xmlErr != nil {
+			return nil, xmlErr
+		}
+		quakes = v.Earthquake
+	}
+
+	reports := []EarthquakeReport{}
+	for _, quake := range quakes {
+		info := quake.EarthquakeInfo
+		local := loadCWBLocal(info.OriginTime)
+
+		intensity := map[string]string{}
+		for _, area := range quake.Intensity.ShakingArea {
+			name := area.AreaName
+			if name == "" {
+				name = area.AreaDesc
+			}
+			value := string(area.AreaIntensity.Value)
+			if _, numErr := strconv.Atoi(value); numErr == nil {
+				value += area.AreaIntensity.Unit
+			}
+			if parseIntensity(value) > parseIntensity(intensity[name]) {
+				intensity[name] = value
+			}
+		}
+
+		reports = append(reports, EarthquakeReport{
+			No:        string(quake.EarthquakeNo),
+			Time:      local,
+			TimeText:  local.Format("15:04"),
+			Epicenter: info.EpiCenter.Location,
+			Latitude:  info.EpiCenter.EpiCenterLat.Value.Float(),
+			Longitude: info.EpiCenter.EpiCenterLon.Value.Float(),
+			Depth:     info.Depth.Value.Float(),
+			Magnitude: info.Magnitude.MagnitudeValue.Float(),
+			Intensity: intensity,
+			Content:   quake.ReportContent,
+			Link:      quake.Web,
+			News:      []RssItem{},
+		})
+	}
+
+	return reports, nil
+}
+
+// loadCWBLocal parses CWB times, which are given in Taiwan time without
+// a zone offset
+func loadCWBLocal(timetext string) time.Time {
+	location, loadLocationErr := time.LoadLocation(timeZone)
+	if loadLocationErr == nil {
+		local, parseErr := time.ParseInLocation("2006-01-02 15:04:05", timetext, location)
+		if parseErr == nil {
+			return local
+		}
+	}
+	return loadLocal(timetext, "")
+}
+
+// parseIntensity converts "3級", "5弱" or "6強" to a comparable number
+func parseIntensity(text string) float64 {
+	text = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(text), "級"))
+	bonus := 0.0
+	if strings.HasSuffix(text, "強") {
+		bonus = 0.5
+	}
+	text = strings.TrimSuffix(strings.TrimSuffix(text, "強"), "弱")
+
+	level, parseErr := strconv.ParseFloat(text, 64)
+	if parseErr != nil {
+		return 0
+	}
+	return level + bonus
+}
+
+// FilterEarthquakeReports keeps the reports above the magnitude and local
+// intensity thresholds
+func FilterEarthquakeReports(reports []EarthquakeReport, conf EarthquakeConfig) []EarthquakeReport {
+	found := []EarthquakeReport{}
+	minIntensity := parseIntensity(conf.MinIntensity)
+
+	for _, report := range reports {
+		if report.Magnitude < conf.MinMagnitude {
+			continue
+		}
+
+		var local string
+		for name, value := range report.Intensity {
+			if strings.Contains(name, conf.County) && parseIntensity(value) > parseIntensity(local) {
+				local = value
+			}
+		}
+		if local == "" || parseIntensity(local) < minIntensity {
+			continue
+		}
+
+		report.LocalIntensity = local
+		found = append(found, report)
+	}
+
+	sort.Sort(ByOriginTime(found))
+
+	return found
+}
+
+// AttachEarthquakeNews links every news item to the latest report issued
+// before it, within earthquakeNewsWindow
+func AttachEarthquakeNews(reports []EarthquakeReport, news []RssItem) []EarthquakeReport {
+	for _, item := range news {
+		for i := range reports {
+			if item.Time.Before(reports[i].Time) {
+				continue
+			}
+			if item.Time.Sub(reports[i].Time) <= earthquakeNewsWindow {
+				reports[i].News = append(reports[i].News, item)
+			}
+			break
+		}
+	}
+	return reports
+}
+
+// cwbReportURL adds the open data authorization key to a CWB url that
+// does not carry one
+func cwbReportURL(rawURL string, key string) string {
+	if key == "" || (!strings.HasPrefix(rawURL, "http://") && !strings.HasPrefix(rawURL, "https://")) {
+		return rawURL
+	}
+	u, parseErr := url.Parse(rawURL)
+	if parseErr != nil {
+		return rawURL
+	}
+	query := u.Query()
+	if query.Get("Authorization") != "" {
+		return rawURL
+	}
+	query.Set("Authorization", key)
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+// LoadEarthquakeReports fetches and filters the configured CWB reports
+func LoadEarthquakeReports(conf EarthquakeConfig) ([]EarthquakeReport, error) {
+	data, fetchErr := fetchReport(cwbReportURL(conf.URL, credentials.Get(SecretCWBAuthorization)))
+	if fetchErr != nil {
+		return nil, fetchErr
+	}
+
+	reports, parseErr := ParseEarthquakeReports(data)
+	if parseErr != nil {
+		return nil, parseErr
+	}
+
+	return FilterEarthquakeReports(reports, conf), nil
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"io/ioutil"
+	"testing"
+)
+
+func loadEarthquakeFixture(t *testing.T, name string) []EarthquakeReport {
+	data, readErr := ioutil.ReadFile("testdata/" + name)
+	if readErr != nil {
+		t.Fatal(readErr)
+	}
+	reports, parseErr := ParseEarthquakeReports(data)
+	if parseErr != nil {
+		t.Fatal(parseErr)
+	}
+	return reports
+}
+
+func TestParseEarthquakeReportsJSON(t *testing.T) {
+	reports := loadEarthquakeFixture(t, "cwb_earthquake.json")
+	if len(reports) != 2 {
+		t.Fatalf("got %d reports, want 2", len(reports))
+	}
+
+	report := reports[0]
+	if report.No != "107042" {
+		t.Errorf("No = %q", report.No)
+	}
+	if got := report.Time.Format("2006-01-02T15:04:05Z07:00"); got != "2018-02-06T23:50:42+08:00" {
+		t.Errorf("Time = %v", got)
+	}
+	if report.Magnitude != 6.2 || report.Depth != 10 || report.Latitude != 24.1 || report.Longitude != 121.73 {
+		t.Errorf("magnitude, depth or epicenter = %v %v %v %v", report.Magnitude, report.Depth, report.Latitude, report.Longitude)
+	}
+	if report.Intensity["新竹市"] != "3級" || report.Intensity["花蓮縣"] != "7級" {
+		t.Errorf("Intensity = %v", report.Intensity)
+	}
+
+	// numbers given as strings
+	if reports[1].Magnitude != 4.1 || reports[1].Depth != 21.3 || reports[1].Intensity["臺東縣"] != "2級" {
+		t.Errorf("second report = %+v", reports[1])
+	}
+}
+
+func TestParseEarthquakeReportsXML(t *testing.T) {
+	reports := loadEarthquakeFixture(t, "cwb_earthquake.xml")
+	if len(reports) != 1 {
+		t.Fatalf("got %d reports, want 1", len(reports))
+	}
+
+	report := reports[0]
+	if report.No != "107043" || report.Magnitude != 5 || report.Depth != 8.2 {
+		t.Errorf("report = %+v", report)
+	}
+	if report.Intensity["花蓮縣"] != "5弱" || report.Intensity["新竹市"] != "1級" {
+		t.Errorf("Intensity = %v", report.Intensity)
+	}
+	if report.Link != "https://scweb.cwb.gov.tw/earthquake/Page.aspx?ItemId=20&Date=201802&No=107043" {
+		t.Errorf("Link = %v", report.Link)
+	}
+}
+
+func TestFilterEarthquakeReports(t *testing.T) {
+	reports := loadEarthquakeFixture(t, "cwb_earthquake.json")
+	reports = append(reports, loadEarthquakeFixture(t, "cwb_earthquake.xml")...)
+
+	cases := []struct {
+		conf EarthquakeConfig
+		want []string
+	}{
+		{EarthquakeConfig{County: "新竹市", MinIntensity: "1級"}, []string{"107043", "107042"}},
+		{EarthquakeConfig{County: "新竹市", MinIntensity: "2級"}, []string{"107042"}},
+		{EarthquakeConfig{County: "新竹市", MinMagnitude: 6.5}, []string{}},
+		{EarthquakeConfig{County: "花蓮縣", MinIntensity: "5強"}, []string{"107042"}},
+	}
+
+	for _, c := range cases {
+		found := FilterEarthquakeReports(reports, c.conf)
+		got := []string{}
+		for _, report := range found {
+			got = append(got, report.No)
+		}
+		if len(got) != len(c.want) {
+			t.Errorf("%+v: got %v, want %v", c.conf, got, c.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != c.want[i] {
+				t.Errorf("%+v: got %v, want %v", c.conf, got, c.want)
+				break
+			}
+		}
+	}
+}
+
+func TestParseIntensity(t *testing.T) {
+	cases := map[string]float64{"3級": 3, "5弱": 5, "5強": 5.5, "6強": 6.5, "": 0, "x": 0}
+	for text, want := range cases {
+		if got := parseIntensity(text); got != want {
+			t.Errorf("parseIntensity(%q) = %v, want %v", text, got, want)
+		}
+	}
+}
+
+func TestCWBReportURL(t *testing.T) {
+	cases := []struct {
+		url, key, want string
+	}{
+		{"https://opendata.cwb.gov.tw/api/v1/rest/datastore/E-A0015-001?format=JSON", "CWB-KEY", "https://opendata.cwb.gov.tw/api/v1/rest/datastore/E-A0015-001?Authorization=CWB-KEY&format=JSON"},
+		{"https://opendata.cwb.gov.tw/api/v1/rest/datastore/E-A0015-001?Authorization=OWN", "CWB-KEY", "https://opendata.cwb.gov.tw/api/v1/rest/datastore/E-A0015-001?Authorization=OWN"},
+		{"https://opendata.cwb.gov.tw/api/v1/rest/datastore/E-A0015-001", "", "https://opendata.cwb.gov.tw/api/v1/rest/datastore/E-A0015-001"},
+		{"testdata/cwb_earthquake.json", "CWB-KEY", "testdata/cwb_earthquake.json"},
+	}
+	for _, c := range cases {
+		if got := cwbReportURL(c.url, c.key); got != c.want {
+			t.Errorf("cwbReportURL(%q) = %q, want %q", c.url, got, c.want)
+		}
+	}
+}
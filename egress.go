@@ -0,0 +1,311 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// EgressConfig struct
+type EgressConfig struct {
+	Schemes      []string `json:"schemes"`
+	AllowHosts   []string `json:"allowHosts"`
+	MaxBytes     int64    `json:"maxBytes"`
+	MaxRedirects int      `json:"maxRedirects"`
+}
+
+// EgressError is a request refused by the outbound url policy or answered
+// with an error upstream, Code is the status to answer with
+type EgressError struct {
+	Code    int
+	Message string
+}
+
+func (e *EgressError) Error() string {
+	return e.Message
+}
+
+// EgressPolicy decides which urls the server may fetch on behalf of
+// feeds and callers
+type EgressPolicy struct {
+	conf   EgressConfig
+	client *http.Client
+}
+
+var blockedNetworks = parseNetworks(
+	"0.0.0.0/8",
+	"10.0.0.0/8",
+	"100.64.0.0/10",
+	"127.0.0.0/8",
+	"169.254.0.0/16",
+	"172.16.0.0/12",
+	"192.0.0.0/24",
+	"192.168.0.0/16",
+	"198.18.0.0/15",
+	"224.0.0.0/4",
+	"240.0.0.0/4",
+	"::/128",
+	"::1/128",
+	"fc00::/7",
+	"fe80::/10",
+	"ff00::/8",
+)
+
+var egress = NewEgressPolicy(defaultConfig().Egress)
+
+func parseNetworks(cidrs ...string) []*net.IPNet {
+	networks := []*net.IPNet{}
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks = append(networks, network)
+	}
+	return networks
+}
+
+// blockedIP reports private, loopback, link-local and other addresses
+// that are not on the public internet
+func blockedIP(ip net.IP) bool {
+	if v4 := ip.To4(); v4 != nil {
+		ip = v4
+	}
+	for _, network := range blockedNetworks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// NewEgressPolicy creates a policy and the client that enforces it
+func NewEgressPolicy(conf EgressConfig) *EgressPolicy {
+	if len(conf.Schemes) == 0 {
+		conf.Schemes = []string{"http", "https"}
+	}
+	if conf.MaxBytes <= 0 {
+		conf.MaxBytes = 5 << 20
+	}
+	if conf.MaxRedirects <= 0 {
+		conf.MaxRedirects = 5
+	}
+
+	p := &EgressPolicy{conf: conf}
+	dialer := &net.Dialer{
+		Timeout:   10 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}
+
+	p.client = &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, network string, addr string) (net.Conn, error) {
+				host, port, splitErr := net.SplitHostPort(addr)
+				if splitErr != nil {
+					return nil, splitErr
+				}
+				ip, resolveErr := p.resolve(host)
+				if resolveErr != nil {
+					return nil, resolveErr
+				}
+				// dial the checked address so that a second lookup cannot
+				// hand out another one
+				return dialer.DialContext(ctx, network, net.JoinHostPort(ip.String(), port))
+			},
+			TLSHandshakeTimeout:   10 * time.Second,
+			ResponseHeaderTimeout: 30 * time.Second,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= p.conf.MaxRedirects {
+				return &EgressError{http.StatusBadRequest, "too many redirects"}
+			}
+			return p.CheckURL(req.URL)
+		},
+	}
+
+	return p
+}
+
+func (p *EgressPolicy) hostAllowed(host string) bool {
+	if len(p.conf.AllowHosts) == 0 {
+		return true
+	}
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	for _, allowed := range p.conf.AllowHosts {
+		allowed = strings.ToLower(strings.TrimPrefix(allowed, "."))
+		if host == allowed || strings.HasSuffix(host, "."+allowed) {
+			return true
+		}
+	}
+	return false
+}
+
+// urlHostname returns the host of a url without port or brackets
+func urlHostname(u *url.URL) string {
+	host := u.Host
+	if h, _, splitErr := net.SplitHostPort(host); splitErr == nil {
+		host = h
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+}
+
+// CheckURL checks the scheme and host of a url before any request
+func (p *EgressPolicy) CheckURL(u *url.URL) error {
+	if !containsString(p.conf.Schemes, strings.ToLower(u.Scheme)) {
+		return &EgressError{http.StatusBadRequest, "scheme " + strconv.Quote(u.Scheme) + " is not allowed"}
+	}
+	host := urlHostname(u)
+	if host == "" {
+		return &EgressError{http.StatusBadRequest, "url has no host"}
+	}
+	if u.User != nil {
+		return &EgressError{http.StatusBadRequest, "url must not carry credentials"}
+	}
+	if !p.hostAllowed(host) {
+		return &EgressError{http.StatusForbidden, "host " + host + " is not allowed"}
+	}
+	if ip := net.ParseIP(host); ip != nil && blockedIP(ip) {
+		return &EgressError{http.StatusForbidden, "address " + host + " is not allowed"}
+	}
+	return nil
+}
+
+// resolve looks a host up and refuses it when any address is blocked
+func (p *EgressPolicy) resolve(host string) (net.IP, error) {
+	ips, lookupErr := net.LookupIP(host)
+	if lookupErr != nil {
+		return nil, lookupErr
+	}
+	if len(ips) == 0 {
+		return nil, errors.New("no address for " + host)
+	}
+	for _, ip := range ips {
+		if blockedIP(ip) {
+			return nil, &EgressError{http.StatusForbidden, "host " + host + " resolves to a blocked address"}
+		}
+	}
+	return ips[0], nil
+}
+
+// asEgressError finds a policy violation inside client errors
+func asEgressError(err error) (*EgressError, bool) {
+	for err != nil {
+		switch e := err.(type) {
+		case *EgressError:
+			return e, true
+		case *url.Error:
+			err = e.Err
+		case *net.OpError:
+			err = e.Err
+		default:
+			return nil, false
+		}
+	}
+	return nil, false
+}
+
+// Fetch gets a url within the policy and the size limit, a status other
+// than 2xx is an error
+func (p *EgressPolicy) Fetch(rawURL string, timeout time.Duration) ([]byte, error) {
+	u, parseErr := url.Parse(rawURL)
+	if parseErr != nil {
+		return nil, &EgressError{http.StatusBadRequest, "url is not valid"}
+	}
+	if checkErr := p.CheckURL(u); checkErr != nil {
+		return nil, checkErr
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	req, reqErr := http.NewRequest("GET", u.String(), nil)
+	if reqErr != nil {
+		return nil, &EgressError{http.StatusBadRequest, "url is not valid"}
+	}
+
+	resp, doErr := p.client.Do(req.WithContext(ctx))
+	if doErr != nil {
+		if egressErr, ok := asEgressError(doErr); ok {
+			return nil, egressErr
+		}
+		return nil, doErr
+	}
+	defer resp.Body.Close()
+
+	// an error page is not an empty feed
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, &EgressError{http.StatusBadGateway, "upstream returned status " + strconv.Itoa(resp.StatusCode)}
+	}
+
+	if resp.ContentLength > p.conf.MaxBytes {
+		return nil, &EgressError{http.StatusRequestEntityTooLarge, "response is larger than " + strconv.FormatInt(p.conf.MaxBytes, 10) + " bytes"}
+	}
+
+	data, readErr := ioutil.ReadAll(io.LimitReader(resp.Body, p.conf.MaxBytes+1))
+	if readErr != nil {
+		return nil, readErr
+	}
+	if int64(len(data)) > p.conf.MaxBytes {
+		return nil, &EgressError{http.StatusRequestEntityTooLarge, "response is larger than " + strconv.FormatInt(p.conf.MaxBytes, 10) + " bytes"}
+	}
+
+	return data, nil
+}
+
+// PostForm posts a form to a url within the policy and returns the
+// status of the response
+func (p *EgressPolicy) PostForm(rawURL string, form url.Values, timeout time.Duration) (int, error) {
+	header := http.Header{"Content-Type": {"application/x-www-form-urlencoded"}}
+	status, _, sendErr := p.Send("POST", rawURL, header, []byte(form.Encode()), timeout)
+	return status, sendErr
+}
+
+// Send makes a request to a url within the policy and returns the status
+// and the body of the response, cut at the size limit
+func (p *EgressPolicy) Send(method string, rawURL string, header http.Header, body []byte, timeout time.Duration) (int, []byte, error) {
+	u, parseErr := url.Parse(rawURL)
+	if parseErr != nil {
+		return 0, nil, &EgressError{http.StatusBadRequest, "url is not valid"}
+	}
+	if checkErr := p.CheckURL(u); checkErr != nil {
+		return 0, nil, checkErr
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	req, reqErr := http.NewRequest(method, u.String(), reader)
+	if reqErr != nil {
+		return 0, nil, &EgressError{http.StatusBadRequest, "url is not valid"}
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+
+	resp, doErr := p.client.Do(req.WithContext(ctx))
+	if doErr != nil {
+		if egressErr, ok := asEgressError(doErr); ok {
+			return 0, nil, egressErr
+		}
+		return 0, nil, doErr
+	}
+	defer resp.Body.Close()
+
+	data, readErr := ioutil.ReadAll(io.LimitReader(resp.Body, p.conf.MaxBytes))
+	if readErr != nil {
+		return resp.StatusCode, nil, readErr
+	}
+	return resp.StatusCode, data, nil
+}
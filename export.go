@@ -0,0 +1,245 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Export formats
+const (
+	ExportCSV  = "csv"
+	ExportXLSX = "xlsx"
+)
+
+const xlsxContentType = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+
+var exportHeader = []string{"日期", "民國日期", "時間", "來源", "標題", "連結", "標籤", "狀態"}
+
+// rocDate formats a date with the ROC (民國) year
+func rocDate(t time.Time) string {
+	return fmt.Sprintf("%d/%02d/%02d", t.Year()-1911, t.Month(), t.Day())
+}
+
+// exportRows turns items into spreadsheet rows, header first
+func exportRows(news []RssItem) [][]string {
+	location, loadLocationErr := time.LoadLocation(timeZone)
+
+	rows := [][]string{exportHeader}
+	for _, item := range news {
+		local := item.Time
+		if loadLocationErr == nil {
+			local = local.In(location)
+		}
+		rows = append(rows, []string{
+			local.Format("2006/01/02"),
+			rocDate(local),
+			local.Format("15:04"),
+			item.Source,
+			item.Title,
+			canonicalLink(item),
+			item.Tag,
+			strconv.Itoa(item.Status),
+		})
+	}
+	return rows
+}
+
+// exportCSV writes rows as CSV with a BOM so that Excel reads UTF-8
+func exportCSV(rows [][]string) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteString("\xEF\xBB\xBF")
+
+	w := csv.NewWriter(&buf)
+	if err := w.WriteAll(rows); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+type xlsxCell struct {
+	Ref    string `xml:"r,attr"`
+	Type   string `xml:"t,attr"`
+	Inline string `xml:"is>t"`
+}
+
+type xlsxRow struct {
+	Index int        `xml:"r,attr"`
+	Cells []xlsxCell `xml:"c"`
+}
+
+type xlsxWorksheet struct {
+	XMLName xml.Name  `xml:"http://schemas.openxmlformats.org/spreadsheetml/2006/main worksheet"`
+	Rows    []xlsxRow `xml:"sheetData>row"`
+}
+
+const xlsxContentTypes = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types"><Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/><Default Extension="xml" ContentType="application/xml"/><Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/><Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/></Types>`
+
+const xlsxRootRels = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships"><Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/></Relationships>`
+
+const xlsxWorkbook = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"><sheets><sheet name="%s" sheetId="1" r:id="rId1"/></sheets></workbook>`
+
+const xlsxWorkbookRels = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships"><Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/></Relationships>`
+
+// xlsxColumn converts a zero based index to a column name, 0 is A
+func xlsxColumn(i int) string {
+	name := ""
+	for i++; i > 0; i = (i - 1) / 26 {
+		name = string(rune('A'+(i-1)%26)) + name
+	}
+	return name
+}
+
+// exportXLSX writes rows as a single sheet workbook using inline strings
+func exportXLSX(sheet string, rows [][]string) ([]byte, error) {
+	worksheet := xlsxWorksheet{}
+	for r, row := range rows {
+		xrow := xlsxRow{Index: r + 1}
+		for c, value := range row {
+			xrow.Cells = append(xrow.Cells, xlsxCell{
+				Ref:    xlsxColumn(c) + strconv.Itoa(r+1),
+				Type:   "inlineStr",
+				Inline: value,
+			})
+		}
+		worksheet.Rows = append(worksheet.Rows, xrow)
+	}
+
+	sheetXML, xmlErr := xml.Marshal(worksheet)
+	if xmlErr != nil {
+		return nil, xmlErr
+	}
+
+	var sheetName bytes.Buffer
+	if escapeErr := xml.EscapeText(&sheetName, []byte(sheet)); escapeErr != nil {
+		return nil, escapeErr
+	}
+
+	parts := []struct {
+		Name string
+		Data []byte
+	}{
+		{"[Content_Types].xml", []byte(xlsxContentTypes)},
+		{"_rels/.rels", []byte(xlsxRootRels)},
+		{"xl/workbook.xml", []byte(fmt.Sprintf(xlsxWorkbook, sheetName.String()))},
+		{"xl/_rels/workbook.xml.rels", []byte(xlsxWorkbookRels)},
+		{"xl/worksheets/sheet1.xml", append([]byte(xml.Header), sheetXML...)},
+	}
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, part := range parts {
+		f, createErr := zw.Create(part.Name)
+		if createErr != nil {
+			return nil, createErr
+		}
+		if _, writeErr := f.Write(part.Data); writeErr != nil {
+			return nil, writeErr
+		}
+	}
+	if closeErr := zw.Close(); closeErr != nil {
+		return nil, closeErr
+	}
+
+	return buf.Bytes(), nil
+}
+
+// exportRange parses from and to as Taipei days, to is included
+func exportRange(fromText string, toText string) (time.Time, time.Time, error) {
+	location, loadLocationErr := time.LoadLocation(timeZone)
+	if loadLocationErr != nil {
+		location = time.UTC
+	}
+
+	now := time.Now().In(location)
+	from := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, location)
+	to := from
+
+	if fromText != "" {
+		var parseErr error
+		from, parseErr = time.ParseInLocation(archiveDateFormat, fromText, location)
+		if parseErr != nil {
+			return from, to, parseErr
+		}
+		to = from
+	}
+	if toText != "" {
+		var parseErr error
+		to, parseErr = time.ParseInLocation(archiveDateFormat, toText, location)
+		if parseErr != nil {
+			return from, to, parseErr
+		}
+	}
+
+	return from, to.AddDate(0, 0, 1).Add(-time.Nanosecond), nil
+}
+
+func exportHandler(topic *Topic) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		format := c.DefaultQuery("format", ExportCSV)
+		if format != ExportCSV && format != ExportXLSX {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "format must be csv or xlsx",
+			})
+			return
+		}
+
+		var news []RssItem
+		var stamp time.Time
+		if c.Query("from") == "" && c.Query("to") == "" {
+			snapshot := topic.Current()
+			news = snapshot.Result.News
+			stamp = snapshot.Time
+		} else {
+			from, to, rangeErr := exportRange(c.Query("from"), c.Query("to"))
+			if rangeErr != nil {
+				c.JSON(http.StatusBadRequest, gin.H{
+					"error": "from and to must look like 2006-01-02",
+				})
+				return
+			}
+			var loadErr error
+			news, loadErr = archive.Load(topic.Name, from, to)
+			if loadErr != nil {
+				c.JSON(http.StatusServiceUnavailable, gin.H{
+					"error": loadErr.Error(),
+				})
+				return
+			}
+			stamp = from
+		}
+
+		rows := exportRows(news)
+		filename := fmt.Sprintf("firenews-%s-%s.%s", topic.Name, strings.Replace(archiveDay(stamp), "-", "", -1), format)
+
+		var data []byte
+		var contentType string
+		var exportErr error
+		if format == ExportXLSX {
+			data, exportErr = exportXLSX(topic.Name, rows)
+			contentType = xlsxContentType
+		} else {
+			data, exportErr = exportCSV(rows)
+			contentType = "text/csv; charset=utf-8"
+		}
+		if exportErr != nil {
+			c.String(http.StatusServiceUnavailable, "%v", exportErr)
+			return
+		}
+
+		c.Header("Content-Disposition", `attachment; filename="`+filename+`"`)
+		c.Data(http.StatusOK, contentType, data)
+	}
+}
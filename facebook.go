@@ -0,0 +1,615 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	fb "github.com/huandu/facebook"
+	"github.com/patrickmn/go-cache"
+)
+
+const (
+	facebookGraphHost  = "graph.facebook.com"
+	facebookPostFields = "id,parent_id,message,story,created_time,updated_time,full_picture,shares," +
+		"attachments{type,title,description,url,unshimmed_url,media,subattachments{type,title,url,media}}," +
+		"reactions.summary(total_count).limit(0),comments.summary(total_count).limit(0)"
+	facebookPageFields = "id,name,link"
+
+	facebookMinShareLength = 20
+)
+
+// Graph node types told apart by metadata
+const (
+	FacebookPageKind  = "page"
+	FacebookGroupKind = "group"
+)
+
+var facebookDisabledErr = errors.New("facebook integration is disabled")
+var facebookBatchErr = errors.New("facebook batch response is incomplete")
+
+// FacebookConfig struct
+type FacebookConfig struct {
+	Version  string `json:"version"`
+	GraphURL string `json:"graphUrl"`
+	MaxPages int    `json:"maxPages"`
+	MaxPosts int    `json:"maxPosts"`
+	MaxAge   int    `json:"maxAge"`
+	Timeout  int    `json:"timeout"`
+	CacheTTL int    `json:"cacheTTL"`
+
+	Watchlists map[string][]FacebookWatch `json:"watchlists"`
+}
+
+// FacebookWatch is a page or group watched for a topic, Include is an
+// optional pattern the message must match
+type FacebookWatch struct {
+	ID      string `json:"id"`
+	Include string `json:"include"`
+}
+
+// FacebookWatchError reports a watched page that could not be read
+type FacebookWatchError struct {
+	ID     string `json:"id"`
+	Status int    `json:"status"`
+	Error  string `json:"error"`
+}
+
+// FacebookPage is the metadata of a page or group
+type FacebookPage struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	Link string `json:"link"`
+	Kind string `json:"kind"`
+}
+
+// FacebookAttachment is a photo, video or link attached to a post
+type FacebookAttachment struct {
+	Type        string `json:"type"`
+	Title       string `json:"title,omitempty"`
+	Description string `json:"description,omitempty"`
+	URL         string `json:"url,omitempty"`
+	Image       string `json:"image,omitempty"`
+}
+
+// FacebookSharedLink is a page shared by a post, NewsID is the id of the
+// news item with the same link when the source is a known one
+type FacebookSharedLink struct {
+	Title  string `json:"title"`
+	URL    string `json:"url"`
+	Source string `json:"source,omitempty"`
+	NewsID string `json:"newsId,omitempty"`
+}
+
+// FacebookError is a Graph API failure with the status to answer with
+type FacebookError struct {
+	Status  int
+	Message string
+}
+
+func (e *FacebookError) Error() string {
+	return e.Message
+}
+
+// FacebookAdapter reads pages and groups through a pinned Graph API
+// version, following pages of results within a budget
+type FacebookAdapter struct {
+	sync.Mutex
+	conf    FacebookConfig
+	client  *http.Client
+	aliases map[string]string
+	pages   map[string]FacebookPage
+	pushed  map[string][]FacebookItem
+	removed map[string]time.Time
+}
+
+var facebookGraph = NewFacebookAdapter(defaultConfig().Facebook)
+
+// graphTransport sends Graph API requests to another server, such as a
+// fake Graph server standing in for facebook
+type graphTransport struct {
+	base *url.URL
+	next http.RoundTripper
+}
+
+func (t graphTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Host != facebookGraphHost {
+		return t.next.RoundTrip(req)
+	}
+
+	redirected := new(http.Request)
+	*redirected = *req
+	u := *req.URL
+	u.Scheme = t.base.Scheme
+	u.Host = t.base.Host
+	u.Path = strings.TrimSuffix(t.base.Path, "/") + u.Path
+	redirected.URL = &u
+	redirected.Host = u.Host
+
+	return t.next.RoundTrip(redirected)
+}
+
+// NewFacebookAdapter creates an adapter, an empty GraphURL talks to
+// facebook itself
+func NewFacebookAdapter(conf FacebookConfig) *FacebookAdapter {
+	if conf.Timeout <= 0 {
+		conf.Timeout = 30
+	}
+
+	var transport http.RoundTripper = http.DefaultTransport
+	if conf.GraphURL != "" {
+		if base, parseErr := url.Parse(conf.GraphURL); parseErr == nil && base.Host != "" {
+			transport = graphTransport{base: base, next: http.DefaultTransport}
+		}
+	}
+
+	return &FacebookAdapter{
+		conf: conf,
+		client: &http.Client{
+			Timeout:   time.Duration(conf.Timeout) * time.Second,
+			Transport: transport,
+		},
+		aliases: make(map[string]string),
+		pages:   make(map[string]FacebookPage),
+		pushed:  make(map[string][]FacebookItem),
+		removed: make(map[string]time.Time),
+	}
+}
+
+func (a *FacebookAdapter) session() (*fb.Session, error) {
+	if status := credentials.Integration(IntegrationFacebook); !status.Enabled {
+		return nil, &FacebookError{http.StatusServiceUnavailable, facebookDisabledErr.Error() + ": " + status.Reason}
+	}
+
+	appID := credentials.Get(SecretFacebookAppID)
+	appSecret := credentials.Get(SecretFacebookAppSecret)
+
+	app := fb.New(appID, appSecret)
+	session := app.Session(appID + "|" + appSecret)
+	session.Version = a.conf.Version
+	session.HttpClient = a.client
+	return session, nil
+}
+
+// facebookStatus maps Graph API error codes to responses
+func facebookStatus(err error) *FacebookError {
+	switch e := err.(type) {
+	case *FacebookError:
+		return e
+	case *fb.Error:
+		switch {
+		case e.Code == 4 || e.Code == 17 || e.Code == 32 || e.Code == 613:
+			return &FacebookError{http.StatusTooManyRequests, e.Message}
+		case e.Code == 100 || e.Code == 803:
+			return &FacebookError{http.StatusNotFound, e.Message}
+		case e.Code == 10 || (e.Code >= 200 && e.Code < 300):
+			return &FacebookError{http.StatusForbidden, e.Message}
+		case e.Code == 102 || e.Code == 190:
+			return &FacebookError{http.StatusServiceUnavailable, "facebook credentials were rejected: " + e.Message}
+		}
+		return &FacebookError{http.StatusBadGateway, e.Message}
+	}
+	return &FacebookError{http.StatusBadGateway, err.Error()}
+}
+
+// resultString reads a string field without trusting its type
+func resultString(res fb.Result, field string) string {
+	switch v := res[field].(type) {
+	case string:
+		return v
+	case nil:
+		return ""
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// batchResult opens one response of a batch request
+func batchResult(res fb.Result) (fb.Result, error) {
+	batch, batchErr := res.Batch()
+	if batchErr != nil {
+		return nil, batchErr
+	}
+	if graphErr := batch.Result.Err(); graphErr != nil {
+		return nil, graphErr
+	}
+	return batch.Result, nil
+}
+
+// fetchFeed gets page metadata and the first page of posts in one batch
+// request, then follows paging.next until a budget runs out
+func (a *FacebookAdapter) fetchFeed(id string) (FacebookPage, []fb.Result, error) {
+	page := FacebookPage{}
+
+	session, sessionErr := a.session()
+	if sessionErr != nil {
+		return page, nil, sessionErr
+	}
+
+	limit := 100
+	if a.conf.MaxPosts > 0 && a.conf.MaxPosts < limit {
+		limit = a.conf.MaxPosts
+	}
+
+	results, batchErr := session.BatchApi(
+		fb.Params{
+			"method":       fb.GET,
+			"relative_url": id + "?metadata=1&fields=" + facebookPageFields,
+		},
+		fb.Params{
+			"method":       fb.GET,
+			"relative_url": fmt.Sprintf("%s/feed?fields=%s&limit=%d", id, url.QueryEscape(facebookPostFields), limit),
+		},
+	)
+	if batchErr != nil {
+		return page, nil, batchErr
+	}
+	if len(results) != 2 {
+		return page, nil, facebookBatchErr
+	}
+
+	meta, metaErr := batchResult(results[0])
+	if metaErr != nil {
+		return page, nil, metaErr
+	}
+	page = FacebookPage{
+		ID:   resultString(meta, "id"),
+		Name: resultString(meta, "name"),
+		Link: resultString(meta, "link"),
+	}
+	if metadata, ok := meta["metadata"].(map[string]interface{}); ok {
+		page.Kind, _ = metadata["type"].(string)
+	}
+
+	feed, feedErr := batchResult(results[1])
+	if feedErr != nil {
+		return page, nil, feedErr
+	}
+	paging, pagingErr := feed.Paging(session)
+	if pagingErr != nil {
+		return page, nil, pagingErr
+	}
+
+	var oldest time.Time
+	if a.conf.MaxAge > 0 {
+		oldest = time.Now().Add(-time.Duration(a.conf.MaxAge) * time.Hour)
+	}
+	deadline := time.Now().Add(time.Duration(a.conf.Timeout) * time.Second)
+
+	posts := []fb.Result{}
+	for pages := 1; ; pages++ {
+		tooOld := false
+		for _, post := range paging.Data() {
+			if !oldest.IsZero() && facebookTime(post).Before(oldest) {
+				tooOld = true
+				continue
+			}
+			posts = append(posts, post)
+		}
+
+		budgetSpent := (a.conf.MaxPosts > 0 && len(posts) >= a.conf.MaxPosts) ||
+			(a.conf.MaxPages > 0 && pages >= a.conf.MaxPages) ||
+			time.Now().After(deadline)
+		if tooOld || budgetSpent || !paging.HasNext() {
+			break
+		}
+
+		noMore, nextErr := paging.Next()
+		if nextErr != nil {
+			return page, posts, nextErr
+		}
+		if noMore {
+			break
+		}
+	}
+
+	if a.conf.MaxPosts > 0 && len(posts) > a.conf.MaxPosts {
+		posts = posts[:a.conf.MaxPosts]
+	}
+
+	return page, posts, nil
+}
+
+// facebookTime returns when a post was created, or last updated
+func facebookTime(post fb.Result) time.Time {
+	local, parseErr := time.Parse(dateTimeFormatFB, resultString(post, "created_time"))
+	if parseErr != nil {
+		local, _ = time.Parse(dateTimeFormatFB, resultString(post, "updated_time"))
+	}
+	return local
+}
+
+// resultMap reads an object field without trusting its type
+func resultMap(res map[string]interface{}, field string) map[string]interface{} {
+	m, _ := res[field].(map[string]interface{})
+	return m
+}
+
+// resultCount reads a count such as shares.count or
+// reactions.summary.total_count
+func resultCount(res map[string]interface{}, field string, path ...string) int {
+	m := resultMap(res, field)
+	for _, key := range path[:len(path)-1] {
+		m = resultMap(m, key)
+	}
+	if count, ok := m[path[len(path)-1]].(float64); ok {
+		return int(count)
+	}
+	return 0
+}
+
+// facebookUnshim returns the target of an l.facebook.com redirect link
+func facebookUnshim(link string) string {
+	u, parseErr := url.Parse(link)
+	if parseErr != nil {
+		return link
+	}
+	if host := strings.ToLower(urlHostname(u)); (host == "l.facebook.com" || host == "lm.facebook.com") && u.Path == "/l.php" {
+		if target := u.Query().Get("u"); target != "" {
+			return target
+		}
+	}
+	return link
+}
+
+// facebookSharedLink runs a shared link through the news source detection
+func facebookSharedLink(title string, link string) *FacebookSharedLink {
+	link = facebookUnshim(link)
+	if link == "" {
+		return nil
+	}
+	shared := &FacebookSharedLink{Title: title, URL: link}
+	if source, keyword := GetNewsSource(link); keyword != "" {
+		shared.Source = source
+		shared.NewsID = itemID(RssItem{Link: link})
+	}
+	return shared
+}
+
+// facebookAttachments reads the attachments of a post, albums being
+// flattened into their photos, and the link it shares if any
+func facebookAttachments(post fb.Result) ([]FacebookAttachment, *FacebookSharedLink) {
+	attachments := []FacebookAttachment{}
+	var shared *FacebookSharedLink
+
+	var add func(data map[string]interface{})
+	add = func(data map[string]interface{}) {
+		list, _ := data["data"].([]interface{})
+		for _, entry := range list {
+			attachment, ok := entry.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			if sub := resultMap(attachment, "subattachments"); sub != nil {
+				add(sub)
+				continue
+			}
+
+			link := resultString(attachment, "unshimmed_url")
+			if link == "" {
+				link = resultString(attachment, "url")
+			}
+			kind := resultString(attachment, "type")
+			attachments = append(attachments, FacebookAttachment{
+				Type:        kind,
+				Title:       resultString(attachment, "title"),
+				Description: resultString(attachment, "description"),
+				URL:         facebookUnshim(link),
+				Image:       resultString(resultMap(resultMap(attachment, "media"), "image"), "src"),
+			})
+			if shared == nil && strings.HasPrefix(kind, "share") {
+				shared = facebookSharedLink(resultString(attachment, "title"), link)
+			}
+		}
+	}
+	add(resultMap(post, "attachments"))
+
+	if len(attachments) == 0 {
+		return nil, shared
+	}
+	return attachments, shared
+}
+
+// facebookText is what include patterns are matched against: the
+// message and the titles of the attachments
+func facebookText(item FacebookItem) string {
+	parts := []string{item.Message}
+	if item.SharedLink != nil {
+		parts = append(parts, item.SharedLink.Title)
+	}
+	for _, attachment := range item.Attachments {
+		if attachment.Title != "" {
+			parts = append(parts, attachment.Title)
+		}
+	}
+	return strings.Join(parts, "\n")
+}
+
+// facebookPermalink links to a post on a page when fbType is "pg" and to
+// a post in a group otherwise
+func facebookPermalink(gid string, pid string, fbType string) string {
+	if fbType == "pg" {
+		return "https://www.facebook.com/permalink.php?story_fbid=" + pid + "&id=" + gid
+	}
+	return "https://www.facebook.com/groups/" + gid + "/permalink/" + pid + "/"
+}
+
+// facebookItems turns posts into items, fbType "pg" links to a page
+// permalink and anything else to a group permalink. Without fbType the
+// node type from the Graph metadata decides.
+func facebookItems(page FacebookPage, posts []fb.Result, fbType string) []FacebookItem {
+	location, loadLocationErr := time.LoadLocation(timeZone)
+
+	if fbType == "" && page.Kind != FacebookGroupKind {
+		fbType = "pg"
+	}
+
+	collect := []FacebookItem{}
+	for _, post := range posts {
+		postID := resultString(post, "id")
+		if postID == "" {
+			continue
+		}
+
+		gid, pid := page.ID, postID
+		if parts := strings.SplitN(postID, "_", 2); len(parts) == 2 {
+			gid, pid = parts[0], parts[1]
+		}
+
+		local := facebookTime(post)
+		if loadLocationErr == nil {
+			local = local.In(location)
+		}
+
+		link := facebookPermalink(gid, pid, fbType)
+		attachments, shared := facebookAttachments(post)
+
+		collect = append(collect, FacebookItem{
+			ID:          postID,
+			ParentID:    resultString(post, "parent_id"),
+			Gid:         gid,
+			Pid:         pid,
+			Story:       resultString(post, "story"),
+			Message:     resultString(post, "message"),
+			Link:        link,
+			OriginLink:  link,
+			Time:        local,
+			TimeText:    local.Format("15:04"),
+			Source:      page.Name,
+			Picture:     resultString(post, "full_picture"),
+			Attachments: attachments,
+			SharedLink:  shared,
+			Reactions:   resultCount(post, "reactions", "summary", "total_count"),
+			Comments:    resultCount(post, "comments", "summary", "total_count"),
+			Shares:      resultCount(post, "shares", "count"),
+		})
+	}
+
+	return collect
+}
+
+// Feed returns the posts of a page or group, cached for CacheTTL seconds,
+// along with the posts pushed by the webhook since
+func (a *FacebookAdapter) Feed(id string, fbType string) ([]FacebookItem, error) {
+	key := "facebook:" + a.conf.Version + ":" + fbType + ":" + id
+	if cached, found := goCache.Get(key); found {
+		if items, ok := cached.([]FacebookItem); ok {
+			return a.withPushed(id, fbType, items), nil
+		}
+	}
+
+	page, posts, fetchErr := a.fetchFeed(id)
+	if fetchErr != nil {
+		return nil, facebookStatus(fetchErr)
+	}
+	a.rememberPage(id, page)
+
+	items := facebookItems(page, posts, fbType)
+	ttl := cache.DefaultExpiration
+	if a.conf.CacheTTL > 0 {
+		ttl = time.Duration(a.conf.CacheTTL) * time.Second
+	}
+	goCache.Set(key, items, ttl)
+
+	return a.withPushed(id, fbType, items), nil
+}
+
+// facebookShareKey is the same for a post and its shares: the shared
+// post, or else a message long enough not to match by chance
+func facebookShareKey(item FacebookItem) string {
+	if item.ParentID != "" {
+		return item.ParentID
+	}
+	if message := normalizedTitle(item.Message); utf8.RuneCountInString(message) >= facebookMinShareLength {
+		return message
+	}
+	return item.ID
+}
+
+// MergeFacebookItems merges timelines, keeping the earliest copy of a
+// post shared to several pages and groups, newest first
+func MergeFacebookItems(lists [][]FacebookItem) []FacebookItem {
+	all := []FacebookItem{}
+	for _, list := range lists {
+		all = append(all, list...)
+	}
+	sort.Sort(sort.Reverse(ByFacebookTime(all)))
+
+	merged := []FacebookItem{}
+	index := make(map[string]int)
+	for _, item := range all {
+		keys := []string{facebookShareKey(item), item.ID}
+		found := -1
+		for _, key := range keys {
+			if i, ok := index[key]; ok {
+				found = i
+				break
+			}
+		}
+		if found < 0 {
+			item.SharedBy = nil
+			merged = append(merged, item)
+			found = len(merged) - 1
+		} else if merged[found].Source != item.Source && !containsString(merged[found].SharedBy, item.Source) {
+			merged[found].SharedBy = append(merged[found].SharedBy, item.Source)
+		}
+		for _, key := range keys {
+			index[key] = found
+		}
+	}
+
+	sort.Sort(ByFacebookTime(merged))
+	return merged
+}
+
+// Timeline reads every page and group watched for a topic
+func (a *FacebookAdapter) Timeline(topic string) ([]FacebookItem, []FacebookWatchError) {
+	watches := a.conf.Watchlists[topic]
+	lists := make([][]FacebookItem, len(watches))
+	failures := make([]*FacebookWatchError, len(watches))
+
+	var wg sync.WaitGroup
+	wg.Add(len(watches))
+	for i, watch := range watches {
+		go func(i int, watch FacebookWatch) {
+			defer wg.Done()
+
+			items, feedErr := a.Feed(watch.ID, "")
+			if feedErr != nil {
+				status := facebookStatus(feedErr)
+				failures[i] = &FacebookWatchError{ID: watch.ID, Status: status.Status, Error: status.Message}
+				return
+			}
+
+			rp, regexpErr := regexp.Compile(watch.Include)
+			if regexpErr != nil {
+				failures[i] = &FacebookWatchError{ID: watch.ID, Status: http.StatusBadRequest, Error: regexpErr.Error()}
+				return
+			}
+			for _, item := range items {
+				if rp.MatchString(CJKnorm(facebookText(item))) {
+					lists[i] = append(lists[i], item)
+				}
+			}
+		}(i, watch)
+	}
+	wg.Wait()
+
+	errs := []FacebookWatchError{}
+	for _, failure := range failures {
+		if failure != nil {
+			log.Printf("Timeline facebookGraph.Feed error: %v %v", failure.ID, failure.Error)
+			errs = append(errs, *failure)
+		}
+	}
+
+	return MergeFacebookItems(lists), errs
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	fb "github.com/huandu/facebook"
+	"github.com/patrickmn/go-cache"
+)
+
+// fakeGraph stands in for the Graph API: one page with two pages of
+// posts, and a missing node answering with error 100
+type fakeGraph struct {
+	sync.Mutex
+	batches int
+	pages   int
+}
+
+func graphPost(id string, message string, created time.Time) map[string]interface{} {
+	return map[string]interface{}{
+		"id":           id,
+		"message":      message,
+		"created_time": created.Format(dateTimeFormatFB),
+		"shares":       map[string]interface{}{"count": 3},
+		"reactions": map[string]interface{}{
+			"summary": map[string]interface{}{"total_count": 12},
+		},
+	}
+}
+
+func graphBody(v interface{}) string {
+	data, _ := json.Marshal(v)
+	return string(data)
+}
+
+func (g *fakeGraph) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	now := time.Now().UTC()
+
+	g.Lock()
+	defer g.Unlock()
+
+	switch {
+	case r.Method == "POST" && r.URL.Path == "/v21.0/":
+		g.batches++
+		var batch []map[string]string
+		if jsonErr := json.Unmarshal([]byte(r.FormValue("batch")), &batch); jsonErr != nil || len(batch) != 2 {
+			http.Error(w, "bad batch", http.StatusBadRequest)
+			return
+		}
+
+		responses := []map[string]interface{}{}
+		for _, req := range batch {
+			switch {
+			case strings.HasPrefix(req["relative_url"], "404?"):
+				responses = append(responses, map[string]interface{}{
+					"code": 400,
+					"body": graphBody(map[string]interface{}{
+						"error": map[string]interface{}{"code": 100, "message": "Unsupported get request."},
+					}),
+				})
+			case strings.HasPrefix(req["relative_url"], "101?"):
+				responses = append(responses, map[string]interface{}{
+					"code": 200,
+					"body": graphBody(map[string]interface{}{
+						"id":       "101",
+						"name":     "新竹市消防局",
+						"link":     "https://www.facebook.com/hcfd",
+						"metadata": map[string]interface{}{"type": "page"},
+					}),
+				})
+			case strings.HasPrefix(req["relative_url"], "101/feed?"):
+				responses = append(responses, map[string]interface{}{
+					"code": 200,
+					"body": graphBody(map[string]interface{}{
+						"data": []interface{}{
+							graphPost("101_1", "新竹市火警", now.Add(-time.Hour)),
+							graphPost("101_2", "救護宣導", now.Add(-2*time.Hour)),
+						},
+						"paging": map[string]interface{}{
+							"next": "https://graph.facebook.com/v21.0/101/feed?after=page2",
+						},
+					}),
+				})
+			default:
+				responses = append(responses, map[string]interface{}{"code": 404, "body": "{}"})
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(graphBody(responses)))
+
+	case r.Method == "GET" && r.URL.Path == "/v21.0/101/feed" && r.URL.Query().Get("after") == "page2":
+		g.pages++
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(graphBody(map[string]interface{}{
+			"data": []interface{}{
+				graphPost("101_3", "水災演習", now.Add(-3*time.Hour)),
+			},
+		})))
+
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func useFakeGraph(conf FacebookConfig) (*FacebookAdapter, *fakeGraph, func()) {
+	os.Setenv(secretEnv(SecretFacebookAppID), "1234567890")
+	os.Setenv(secretEnv(SecretFacebookAppSecret), "0123456789abcdef0123456789abcdef")
+	saved, savedCache := credentials, goCache
+	credentials = NewCredentials(CredentialsConfig{})
+	credentials.Reload()
+	goCache = cache.New(time.Minute, time.Minute)
+
+	graph := &fakeGraph{}
+	server := httptest.NewServer(graph)
+	conf.Version = "v21.0"
+	conf.GraphURL = server.URL
+
+	return NewFacebookAdapter(conf), graph, func() {
+		server.Close()
+		credentials, goCache = saved, savedCache
+		os.Unsetenv(secretEnv(SecretFacebookAppID))
+		os.Unsetenv(secretEnv(SecretFacebookAppSecret))
+	}
+}
+
+func TestFacebookFeedFollowsPaging(t *testing.T) {
+	adapter, graph, done := useFakeGraph(FacebookConfig{MaxPages: 5, Timeout: 5})
+	defer done()
+
+	items, feedErr := adapter.Feed("101", "")
+	if feedErr != nil {
+		t.Fatal(feedErr)
+	}
+	if graph.batches != 1 || graph.pages != 1 {
+		t.Errorf("batches = %d, pages = %d, want 1 and 1", graph.batches, graph.pages)
+	}
+	if len(items) != 3 {
+		t.Fatalf("got %d items, want 3", len(items))
+	}
+
+	item := items[0]
+	if item.ID != "101_1" || item.Gid != "101" || item.Pid != "1" || item.Source != "新竹市消防局" {
+		t.Errorf("item = %+v", item)
+	}
+	if item.Link != "https://www.facebook.com/permalink.php?story_fbid=1&id=101" {
+		t.Errorf("Link = %v", item.Link)
+	}
+	if item.Shares != 3 || item.Reactions != 12 {
+		t.Errorf("Shares = %d, Reactions = %d", item.Shares, item.Reactions)
+	}
+	if items[2].Message != "水災演習" {
+		t.Errorf("last item = %+v", items[2])
+	}
+}
+
+func TestFacebookFeedPageBudget(t *testing.T) {
+	adapter, graph, done := useFakeGraph(FacebookConfig{MaxPages: 1, Timeout: 5})
+	defer done()
+
+	items, feedErr := adapter.Feed("101", "")
+	if feedErr != nil {
+		t.Fatal(feedErr)
+	}
+	if len(items) != 2 || graph.pages != 0 {
+		t.Errorf("got %d items and %d extra pages, want 2 and 0", len(items), graph.pages)
+	}
+}
+
+func TestFacebookFeedError(t *testing.T) {
+	adapter, _, done := useFakeGraph(FacebookConfig{Timeout: 5})
+	defer done()
+
+	_, feedErr := adapter.Feed("404", "")
+	status, ok := feedErr.(*FacebookError)
+	if !ok {
+		t.Fatalf("error = %#v", feedErr)
+	}
+	if status.Status != http.StatusNotFound || status.Message != "Unsupported get request." {
+		t.Errorf("status = %+v", status)
+	}
+}
+
+func TestFacebookStatus(t *testing.T) {
+	cases := []struct {
+		code   int
+		status int
+	}{
+		{4, http.StatusTooManyRequests},
+		{17, http.StatusTooManyRequests},
+		{32, http.StatusTooManyRequests},
+		{613, http.StatusTooManyRequests},
+		{100, http.StatusNotFound},
+		{803, http.StatusNotFound},
+		{10, http.StatusForbidden},
+		{200, http.StatusForbidden},
+		{299, http.StatusForbidden},
+		{102, http.StatusServiceUnavailable},
+		{190, http.StatusServiceUnavailable},
+		{1, http.StatusBadGateway},
+	}
+	for _, c := range cases {
+		status := facebookStatus(&fb.Error{Code: c.code, Message: fmt.Sprint("code ", c.code)})
+		if status.Status != c.status {
+			t.Errorf("code %d = %d, want %d", c.code, status.Status, c.status)
+		}
+	}
+
+	if status := facebookStatus(fmt.Errorf("cannot reach facebook server")); status.Status != http.StatusBadGateway {
+		t.Errorf("network error = %d", status.Status)
+	}
+	disabled := &FacebookError{http.StatusServiceUnavailable, facebookDisabledErr.Error()}
+	if status := facebookStatus(disabled); status != disabled {
+		t.Errorf("adapter error = %+v", status)
+	}
+}
@@ -0,0 +1,382 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"hash"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	facebookHookMaxBytes = 1 << 20
+	facebookPushedLimit  = 100
+)
+
+var facebookSignatureErr = errors.New("X-Hub-Signature does not match the payload")
+
+// FacebookHookPayload is a change notification sent by the Graph webhooks
+type FacebookHookPayload struct {
+	Object string              `json:"object"`
+	Entry  []FacebookHookEntry `json:"entry"`
+}
+
+// FacebookHookEntry holds the changes to one page
+type FacebookHookEntry struct {
+	ID      string               `json:"id"`
+	Time    int64                `json:"time"`
+	Changes []FacebookHookChange `json:"changes"`
+}
+
+// FacebookHookChange struct
+type FacebookHookChange struct {
+	Field string           `json:"field"`
+	Value FacebookHookPost `json:"value"`
+}
+
+// FacebookHookPost is the value of a feed change
+type FacebookHookPost struct {
+	Item        string      `json:"item"`
+	Verb        string      `json:"verb"`
+	PostID      string      `json:"post_id"`
+	ParentID    string      `json:"parent_id"`
+	Message     string      `json:"message"`
+	Link        string      `json:"link"`
+	Photo       string      `json:"photo"`
+	Photos      []string    `json:"photos"`
+	CreatedTime interface{} `json:"created_time"`
+	Published   interface{} `json:"published"`
+	From        struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	} `json:"from"`
+}
+
+// feed items that are posts, as opposed to comments and reactions
+var facebookPostItems = []string{"status", "post", "photo", "video", "share", "link", "note", "event"}
+
+// rememberPage records the page a requested id or name resolved to
+func (a *FacebookAdapter) rememberPage(id string, page FacebookPage) {
+	if page.ID == "" {
+		return
+	}
+	a.Lock()
+	defer a.Unlock()
+	a.aliases[id] = page.ID
+	a.pages[page.ID] = page
+}
+
+// withPushed merges the posts pushed for a page into fetched ones, the
+// pushed copy winning as it is the newer but keeping the attachments and
+// counts only the Graph API returns, and drops removed posts
+func (a *FacebookAdapter) withPushed(id string, fbType string, items []FacebookItem) []FacebookItem {
+	a.Lock()
+	pageID := id
+	if alias, found := a.aliases[id]; found {
+		pageID = alias
+	}
+	pushed := append([]FacebookItem{}, a.pushed[pageID]...)
+	removed := make(map[string]bool)
+	for postID := range a.removed {
+		removed[postID] = true
+	}
+	a.Unlock()
+
+	if len(pushed) == 0 && len(removed) == 0 {
+		return items
+	}
+
+	if fbType == "" {
+		fbType = "pg"
+	}
+
+	fetched := make(map[string]FacebookItem)
+	for _, item := range items {
+		fetched[item.ID] = item
+	}
+
+	seen := make(map[string]bool)
+	merged := []FacebookItem{}
+	for _, item := range pushed {
+		item.Link = facebookPermalink(item.Gid, item.Pid, fbType)
+		item.OriginLink = item.Link
+		if previous, found := fetched[item.ID]; found {
+			if len(previous.Attachments) > 0 {
+				item.Attachments = previous.Attachments
+			}
+			if previous.Picture != "" {
+				item.Picture = previous.Picture
+			}
+			if item.SharedLink == nil {
+				item.SharedLink = previous.SharedLink
+			}
+			item.Reactions = previous.Reactions
+			item.Comments = previous.Comments
+			item.Shares = previous.Shares
+		}
+		seen[item.ID] = true
+		merged = append(merged, item)
+	}
+	for _, item := range items {
+		if seen[item.ID] || removed[item.ID] {
+			continue
+		}
+		merged = append(merged, item)
+	}
+
+	sort.Sort(ByFacebookTime(merged))
+	return merged
+}
+
+// Push inserts or replaces a post sent by the webhook, keeping the
+// newest posts of each page within MaxAge
+func (a *FacebookAdapter) Push(pageID string, item FacebookItem) {
+	a.Lock()
+	defer a.Unlock()
+
+	delete(a.removed, item.ID)
+
+	posts := []FacebookItem{item}
+	for _, post := range a.pushed[pageID] {
+		if post.ID != item.ID {
+			posts = append(posts, post)
+		}
+	}
+	sort.Sort(ByFacebookTime(posts))
+
+	if a.conf.MaxAge > 0 {
+		oldest := time.Now().Add(-time.Duration(a.conf.MaxAge) * time.Hour)
+		for len(posts) > 0 && posts[len(posts)-1].Time.Before(oldest) {
+			posts = posts[:len(posts)-1]
+		}
+	}
+	if len(posts) > facebookPushedLimit {
+		posts = posts[:facebookPushedLimit]
+	}
+
+	a.pushed[pageID] = posts
+}
+
+// Remove hides a post that was deleted or hidden on facebook
+func (a *FacebookAdapter) Remove(pageID string, postID string) {
+	a.Lock()
+	defer a.Unlock()
+
+	posts := []FacebookItem{}
+	for _, post := range a.pushed[pageID] {
+		if post.ID != postID {
+			posts = append(posts, post)
+		}
+	}
+	a.pushed[pageID] = posts
+
+	now := time.Now()
+	a.removed[postID] = now
+	for id, at := range a.removed {
+		if now.Sub(at) > 7*24*time.Hour {
+			delete(a.removed, id)
+		}
+	}
+}
+
+// facebookUnixTime reads a timestamp sent as a number or a string
+func facebookUnixTime(v interface{}) time.Time {
+	switch t := v.(type) {
+	case float64:
+		return time.Unix(int64(t), 0)
+	case string:
+		if seconds, parseErr := strconv.ParseInt(t, 10, 64); parseErr == nil {
+			return time.Unix(seconds, 0)
+		}
+		if local, parseErr := time.Parse(dateTimeFormatFB, t); parseErr == nil {
+			return local
+		}
+	}
+	return time.Now()
+}
+
+// hookItem turns a feed change into an item of the page
+func (a *FacebookAdapter) hookItem(pageID string, post FacebookHookPost) FacebookItem {
+	gid, pid := pageID, post.PostID
+	if parts := strings.SplitN(post.PostID, "_", 2); len(parts) == 2 {
+		gid, pid = parts[0], parts[1]
+	}
+
+	local := facebookUnixTime(post.CreatedTime)
+	if location, loadLocationErr := time.LoadLocation(timeZone); loadLocationErr == nil {
+		local = local.In(location)
+	}
+
+	a.Lock()
+	source := a.pages[pageID].Name
+	a.Unlock()
+	if source == "" {
+		source = post.From.Name
+	}
+
+	picture := post.Photo
+	if picture == "" && len(post.Photos) > 0 {
+		picture = post.Photos[0]
+	}
+
+	var shared *FacebookSharedLink
+	if post.Link != "" && post.Item != "photo" {
+		shared = facebookSharedLink("", post.Link)
+	}
+
+	link := facebookPermalink(gid, pid, "pg")
+	return FacebookItem{
+		ID:         post.PostID,
+		ParentID:   post.ParentID,
+		Gid:        gid,
+		Pid:        pid,
+		Message:    post.Message,
+		Link:       link,
+		OriginLink: link,
+		Time:       local,
+		TimeText:   local.Format("15:04"),
+		Source:     source,
+		Picture:    picture,
+		SharedLink: shared,
+	}
+}
+
+// Apply applies the feed changes of a notification and returns how many
+// posts were added, edited or removed
+func (a *FacebookAdapter) Apply(payload FacebookHookPayload) int {
+	applied := 0
+	for _, entry := range payload.Entry {
+		for _, change := range entry.Changes {
+			post := change.Value
+			if change.Field != "feed" || post.PostID == "" || !containsString(facebookPostItems, post.Item) {
+				continue
+			}
+			if published, ok := post.Published.(float64); ok && published == 0 {
+				continue
+			}
+
+			switch post.Verb {
+			case "add", "edited":
+				a.Push(entry.ID, a.hookItem(entry.ID, post))
+			case "remove", "hide":
+				a.Remove(entry.ID, post.PostID)
+			default:
+				continue
+			}
+			applied++
+		}
+	}
+	return applied
+}
+
+// validHookSignature checks X-Hub-Signature-256, or X-Hub-Signature
+// when only the sha1 one is sent, against the app secret
+func validHookSignature(body []byte, sha256Header string, sha1Header string, secret string) bool {
+	var mac hash.Hash
+	var signature string
+	switch {
+	case strings.HasPrefix(sha256Header, "sha256="):
+		mac = hmac.New(sha256.New, []byte(secret))
+		signature = strings.TrimPrefix(sha256Header, "sha256=")
+	case strings.HasPrefix(sha1Header, "sha1="):
+		mac = hmac.New(sha1.New, []byte(secret))
+		signature = strings.TrimPrefix(sha1Header, "sha1=")
+	default:
+		return false
+	}
+
+	expected, decodeErr := hex.DecodeString(signature)
+	if decodeErr != nil {
+		return false
+	}
+	mac.Write(body)
+	return hmac.Equal(mac.Sum(nil), expected)
+}
+
+func facebookHookDisabled(c *gin.Context) bool {
+	status := credentials.Integration(IntegrationFacebookWebhook)
+	if !status.Enabled {
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"error": "facebook webhook is disabled: " + status.Reason,
+		})
+	}
+	return !status.Enabled
+}
+
+// facebookVerifyHandler answers the subscription handshake
+func facebookVerifyHandler(c *gin.Context) {
+	if facebookHookDisabled(c) {
+		return
+	}
+
+	token := []byte(c.Query("hub.verify_token"))
+	expected := []byte(credentials.Get(SecretFacebookVerifyToken))
+	if c.Query("hub.mode") != "subscribe" || subtle.ConstantTimeCompare(token, expected) != 1 {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": "verify token does not match",
+		})
+		return
+	}
+
+	c.String(http.StatusOK, "%s", c.Query("hub.challenge"))
+}
+
+// facebookHookHandler receives page feed changes
+func facebookHookHandler(c *gin.Context) {
+	if facebookHookDisabled(c) {
+		return
+	}
+
+	body, readErr := ioutil.ReadAll(io.LimitReader(c.Request.Body, facebookHookMaxBytes+1))
+	if readErr != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": readErr.Error(),
+		})
+		return
+	}
+	if len(body) > facebookHookMaxBytes {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{
+			"error": "payload is too large",
+		})
+		return
+	}
+
+	if !validHookSignature(body, c.Request.Header.Get("X-Hub-Signature-256"), c.Request.Header.Get("X-Hub-Signature"), credentials.Get(SecretFacebookAppSecret)) {
+		log.Printf("facebookHookHandler validHookSignature error: %v", facebookSignatureErr)
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": facebookSignatureErr.Error(),
+		})
+		return
+	}
+
+	var payload FacebookHookPayload
+	if jsonErr := json.Unmarshal(body, &payload); jsonErr != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": jsonErr.Error(),
+		})
+		return
+	}
+	if payload.Object != "page" {
+		c.JSON(http.StatusOK, gin.H{
+			"applied": 0,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"applied": facebookGraph.Apply(payload),
+	})
+}
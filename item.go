@@ -0,0 +1,336 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	seenFile       = "seen.json"
+	seenTTL        = 90 * 24 * time.Hour
+	itemSearchDays = 30
+	relatedWindow  = 3 * 24 * time.Hour
+
+	// the archive of the last days is read at most once per
+	// itemArchiveTTL, keeping the newest itemArchiveMax items of a topic
+	itemArchiveTTL = time.Minute
+	itemArchiveMax = 5000
+)
+
+var itemNotFoundErr = errors.New("item not found")
+
+// tracking parameters that do not change what a link points at, from and
+// ref are left alone since news sites use them to pick the article
+var trackingParams = []string{"utm_", "fbclid", "gclid"}
+
+// ItemSeen records when an item was first and last fetched
+type ItemSeen struct {
+	First time.Time `json:"first"`
+	Last  time.Time `json:"last"`
+}
+
+// ItemSource is one place an item was carried
+type ItemSource struct {
+	Topic  string    `json:"topic"`
+	Source string    `json:"source"`
+	Tag    string    `json:"tag"`
+	Link   string    `json:"link"`
+	Title  string    `json:"title"`
+	Time   time.Time `json:"time"`
+}
+
+// ItemDetail is the full record of an item
+type ItemDetail struct {
+	Item      RssItem      `json:"item"`
+	FirstSeen time.Time    `json:"firstSeen"`
+	LastSeen  time.Time    `json:"lastSeen"`
+	Sources   []ItemSource `json:"sources"`
+	Related   []RssItem    `json:"related"`
+	History   []ItemState  `json:"history"`
+}
+
+type topicItem struct {
+	Topic string
+	Item  RssItem
+}
+
+// itemArchiveCache keeps the archived items of the last days between
+// item lookups
+type itemArchiveCache struct {
+	sync.Mutex
+	loaded time.Time
+	items  []topicItem
+}
+
+var archivedItems = &itemArchiveCache{}
+
+// canonicalURL drops what differs between links to the same page: the
+// scheme, a default port, the fragment, tracking parameters and a
+// trailing slash
+func canonicalURL(link string) string {
+	u, parseErr := url.Parse(strings.TrimSpace(link))
+	if parseErr != nil || u.Host == "" {
+		return strings.TrimSpace(link)
+	}
+
+	host := strings.ToLower(u.Host)
+	host = strings.TrimSuffix(host, ":80")
+	host = strings.TrimSuffix(host, ":443")
+
+	query := u.Query()
+	for key := range query {
+		for _, param := range trackingParams {
+			if key == param || (strings.HasSuffix(param, "_") && strings.HasPrefix(key, param)) {
+				query.Del(key)
+			}
+		}
+	}
+
+	path := strings.TrimSuffix(u.EscapedPath(), "/")
+	canonical := host + path
+	if encoded := query.Encode(); encoded != "" {
+		canonical += "?" + encoded
+	}
+	return canonical
+}
+
+// itemID returns a stable identifier based on the canonical url, or on
+// the source and title for items without a link
+func itemID(item RssItem) string {
+	key := canonicalURL(canonicalLink(item))
+	if key == "" {
+		key = item.Source + "|" + item.Title
+	}
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:16])
+}
+
+// itemURN is the id used by feeds
+func itemURN(item RssItem) string {
+	return "urn:firenews:" + itemID(item)
+}
+
+// loadSeen reads the seen index once, the caller holds the lock
+func (a *Archive) loadSeen() error {
+	if a.seen != nil {
+		return nil
+	}
+
+	seen := make(map[string]*ItemSeen)
+	data, readErr := ioutil.ReadFile(filepath.Join(a.dir, seenFile))
+	if readErr != nil && !os.IsNotExist(readErr) {
+		return readErr
+	}
+	if readErr == nil {
+		if jsonErr := json.Unmarshal(data, &seen); jsonErr != nil {
+			return jsonErr
+		}
+	}
+
+	a.seen = seen
+	return nil
+}
+
+// markSeen updates the seen index, the caller holds the lock
+func (a *Archive) markSeen(news []RssItem) error {
+	if loadErr := a.loadSeen(); loadErr != nil {
+		return loadErr
+	}
+
+	now := time.Now()
+	for _, item := range news {
+		id := itemID(item)
+		if record := a.seen[id]; record != nil {
+			record.Last = now
+		} else {
+			a.seen[id] = &ItemSeen{First: now, Last: now}
+		}
+	}
+	for id, record := range a.seen {
+		if now.Sub(record.Last) > seenTTL {
+			delete(a.seen, id)
+		}
+	}
+
+	if mkdirErr := os.MkdirAll(a.dir, 0755); mkdirErr != nil {
+		return mkdirErr
+	}
+	data, jsonErr := json.Marshal(a.seen)
+	if jsonErr != nil {
+		return jsonErr
+	}
+	path := filepath.Join(a.dir, seenFile)
+	tmp := path + ".tmp"
+	if writeErr := ioutil.WriteFile(tmp, data, 0644); writeErr != nil {
+		return writeErr
+	}
+	return os.Rename(tmp, path)
+}
+
+// Seen returns when an item was first and last fetched
+func (a *Archive) Seen(id string) (ItemSeen, bool) {
+	a.Lock()
+	defer a.Unlock()
+
+	if loadErr := a.loadSeen(); loadErr != nil {
+		return ItemSeen{}, false
+	}
+	if record := a.seen[id]; record != nil {
+		return *record, true
+	}
+	return ItemSeen{}, false
+}
+
+// snapshotItems returns the items of every topic snapshot
+func snapshotItems(topics *TopicRegistry) []topicItem {
+	collect := []topicItem{}
+	for _, topic := range topics.List() {
+		if snapshot, found := snapshots.Get(topic.Name); found {
+			for _, item := range snapshot.Result.News {
+				collect = append(collect, topicItem{Topic: topic.Name, Item: item})
+			}
+		}
+	}
+	return collect
+}
+
+// Items returns the archived items of the last days, read again once
+// the cached ones are older than itemArchiveTTL
+func (c *itemArchiveCache) Items(topics *TopicRegistry) []topicItem {
+	c.Lock()
+	defer c.Unlock()
+
+	if c.items != nil && time.Since(c.loaded) < itemArchiveTTL {
+		return c.items
+	}
+
+	to := time.Now()
+	from := to.AddDate(0, 0, -itemSearchDays)
+	collect := []topicItem{}
+	for _, topic := range topics.List() {
+		news, loadErr := archive.Load(topic.Name, from, to)
+		if loadErr != nil {
+			continue
+		}
+		if len(news) > itemArchiveMax {
+			news = news[:itemArchiveMax]
+		}
+		for _, item := range news {
+			collect = append(collect, topicItem{Topic: topic.Name, Item: item})
+		}
+	}
+
+	c.items = collect
+	c.loaded = to
+	return collect
+}
+
+// lookupItem finds an item by id among candidates
+func lookupItem(candidates []topicItem, id string) (RssItem, bool) {
+	for _, candidate := range candidates {
+		if itemID(candidate.Item) == id {
+			return candidate.Item, true
+		}
+	}
+	return RssItem{}, false
+}
+
+// findItem looks an item up in the topic snapshots, then in the archive
+// of the last days
+func findItem(topics *TopicRegistry, id string) (RssItem, bool) {
+	if item, found := lookupItem(snapshotItems(topics), id); found {
+		return item, true
+	}
+	return lookupItem(archivedItems.Items(topics), id)
+}
+
+// normalizedTitle compares titles the way UniqueElements does
+func normalizedTitle(title string) string {
+	return strings.Replace(CJKnorm(strings.TrimSpace(title)), " ", "", -1)
+}
+
+// ItemDetails gathers every source that carried an item, either with
+// the same id or with the same title, and items about the same event
+func ItemDetails(topics *TopicRegistry, id string) (ItemDetail, bool) {
+	var detail ItemDetail
+	var found bool
+	if detail.Item, found = findItem(topics, id); !found {
+		return detail, false
+	}
+
+	// snapshots come first, so their copy of an item wins
+	candidates := append(snapshotItems(topics), archivedItems.Items(topics)...)
+
+	title := normalizedTitle(detail.Item.Title)
+	bigrams := titleBigrams(detail.Item.Title)
+
+	sources := make(map[string]bool)
+	related := make(map[string]bool)
+	detail.Sources = []ItemSource{}
+	detail.Related = []RssItem{}
+	for _, candidate := range candidates {
+		item := candidate.Item
+		candidateID := itemID(item)
+
+		if candidateID == id || normalizedTitle(item.Title) == title {
+			key := candidate.Topic + "|" + candidateID
+			if !sources[key] {
+				sources[key] = true
+				detail.Sources = append(detail.Sources, ItemSource{
+					Topic:  candidate.Topic,
+					Source: item.Source,
+					Tag:    item.Tag,
+					Link:   canonicalLink(item),
+					Title:  item.Title,
+					Time:   item.Time,
+				})
+			}
+			continue
+		}
+
+		gap := item.Time.Sub(detail.Item.Time)
+		if gap < -relatedWindow || gap > relatedWindow || related[candidateID] {
+			continue
+		}
+		if bigramSimilarity(bigrams, titleBigrams(item.Title)) >= eventSimilarity {
+			related[candidateID] = true
+			detail.Related = append(detail.Related, item)
+		}
+	}
+	sort.Sort(ByTime(detail.Related))
+
+	if seen, seenFound := archive.Seen(id); seenFound {
+		detail.FirstSeen = seen.First
+		detail.LastSeen = seen.Last
+	}
+
+	detail.Item = AttachStates([]RssItem{detail.Item})[0]
+	detail.Related = AttachStates(detail.Related)
+	detail.History = itemHistory(id)
+
+	return detail, true
+}
+
+func itemDetailHandler(topics *TopicRegistry) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		detail, found := ItemDetails(topics, c.Param("id"))
+		if !found {
+			c.JSON(http.StatusNotFound, gin.H{"error": itemNotFoundErr.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, detail)
+	}
+}
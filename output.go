@@ -0,0 +1,219 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/feeds"
+)
+
+// Output formats for topic endpoints
+const (
+	FormatJSON     = "json"
+	FormatRSS      = "rss"
+	FormatAtom     = "atom"
+	FormatJSONFeed = "jsonfeed"
+)
+
+const jsonFeedVersion = "https://jsonfeed.org/version/1"
+
+// JSONFeed struct
+type JSONFeed struct {
+	Version     string         `json:"version"`
+	Title       string         `json:"title"`
+	HomePageURL string         `json:"home_page_url,omitempty"`
+	FeedURL     string         `json:"feed_url,omitempty"`
+	Items       []JSONFeedItem `json:"items"`
+}
+
+// JSONFeedItem struct
+type JSONFeedItem struct {
+	ID            string                `json:"id"`
+	URL           string                `json:"url"`
+	Title         string                `json:"title"`
+	ContentHTML   string                `json:"content_html"`
+	DatePublished string                `json:"date_published"`
+	Author        *JSONFeedAuthor       `json:"author,omitempty"`
+	Tags          []string              `json:"tags,omitempty"`
+	Firenews      JSONFeedItemExtension `json:"_firenews"`
+}
+
+// JSONFeedAuthor struct
+type JSONFeedAuthor struct {
+	Name string `json:"name"`
+}
+
+// JSONFeedItemExtension keeps the fields JSON Feed has no place for
+type JSONFeedItemExtension struct {
+	Source string `json:"source"`
+	Tag    string `json:"tag"`
+	Status int    `json:"status"`
+	Area   string `json:"area,omitempty"`
+}
+
+// requestFormat picks the output format from ?format= or the Accept header
+func requestFormat(c *gin.Context) string {
+	switch strings.ToLower(c.Query("format")) {
+	case FormatRSS:
+		return FormatRSS
+	case FormatAtom:
+		return FormatAtom
+	case FormatJSONFeed:
+		return FormatJSONFeed
+	case FormatJSON:
+		return FormatJSON
+	}
+
+	accept := c.Request.Header.Get("Accept")
+	switch {
+	case strings.Contains(accept, "application/rss+xml"):
+		return FormatRSS
+	case strings.Contains(accept, "application/atom+xml"):
+		return FormatAtom
+	case strings.Contains(accept, "application/feed+json"):
+		return FormatJSONFeed
+	}
+
+	return FormatJSON
+}
+
+// canonicalLink prefers the original link over the shortened one
+func canonicalLink(item RssItem) string {
+	if item.OriginLink != "" {
+		return item.OriginLink
+	}
+	return item.Link
+}
+
+func requestURL(c *gin.Context) string {
+	scheme := "http"
+	if c.Request.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + c.Request.Host + c.Request.URL.RequestURI()
+}
+
+// newsFeed builds a gorilla feed for a topic
+func newsFeed(topic string, link string, news []RssItem) *feeds.Feed {
+	feed := &feeds.Feed{
+		Title:       "firenews " + topic,
+		Link:        &feeds.Link{Href: link},
+		Description: "firenews " + topic,
+		Id:          link,
+		Items:       make([]*feeds.Item, 0),
+	}
+
+	for _, item := range news {
+		if item.Time.After(feed.Updated) {
+			feed.Updated = item.Time
+		}
+		feed.Items = append(feed.Items, &feeds.Item{
+			Title:       item.Title,
+			Link:        &feeds.Link{Href: canonicalLink(item)},
+			Author:      &feeds.Author{Name: item.Source},
+			Description: item.Description,
+			Id:          itemURN(item),
+			Created:     item.Time,
+		})
+	}
+
+	if feed.Updated.IsZero() {
+		feed.Updated = time.Now()
+	}
+
+	return feed
+}
+
+// rssFeed renders a topic as RSS 2.0, an RSS author must be an email and a
+// source needs the url of its channel, so neither is written
+func rssFeed(topic string, link string, news []RssItem) (string, error) {
+	rss := (&feeds.Rss{Feed: newsFeed(topic, link, news)}).RssFeed()
+	for i, item := range rss.Items {
+		item.Author = ""
+		item.Category = news[i].Tag
+	}
+	return feeds.ToXML(rss)
+}
+
+// atomFeed renders a topic as Atom, the source name goes in the entry
+// author since an atom source has to describe a whole feed
+func atomFeed(topic string, link string, news []RssItem) (string, error) {
+	atom := (&feeds.Atom{Feed: newsFeed(topic, link, news)}).AtomFeed()
+	for i, entry := range atom.Entries {
+		entry.Published = news[i].Time.Format(time.RFC3339)
+	}
+	return feeds.ToXML(atom)
+}
+
+// jsonFeed renders a topic as JSON Feed
+func jsonFeed(topic string, link string, news []RssItem) JSONFeed {
+	feed := JSONFeed{
+		Version: jsonFeedVersion,
+		Title:   "firenews " + topic,
+		FeedURL: link,
+		Items:   []JSONFeedItem{},
+	}
+
+	for _, item := range news {
+		var tags []string
+		if item.Tag != "" {
+			tags = []string{item.Tag}
+		}
+		feed.Items = append(feed.Items, JSONFeedItem{
+			ID:            itemURN(item),
+			URL:           canonicalLink(item),
+			Title:         item.Title,
+			ContentHTML:   item.Description,
+			DatePublished: item.Time.Format(time.RFC3339),
+			Author:        &JSONFeedAuthor{Name: item.Source},
+			Tags:          tags,
+			Firenews: JSONFeedItemExtension{
+				Source: item.Source,
+				Tag:    item.Tag,
+				Status: item.Status,
+				Area:   item.Area,
+			},
+		})
+	}
+
+	return feed
+}
+
+// renderNews writes topic results in the requested format, extra fields
+// are only kept in the plain JSON output. RSS and Atom topic feeds link
+// to the WebSub hub when it is on.
+func renderNews(c *gin.Context, topic string, news []RssItem, extra gin.H) {
+	link := requestURL(c)
+
+	switch requestFormat(c) {
+	case FormatRSS:
+		rss, err := rssFeed(topic, link, news)
+		if err != nil {
+			log.Println(err)
+			c.String(http.StatusServiceUnavailable, "%v", err)
+			return
+		}
+		c.Data(http.StatusOK, "application/rss+xml; charset=utf-8", []byte(websubHub.advertise(c, FormatRSS, rss)))
+	case FormatAtom:
+		atom, err := atomFeed(topic, link, news)
+		if err != nil {
+			log.Println(err)
+			c.String(http.StatusServiceUnavailable, "%v", err)
+			return
+		}
+		c.Data(http.StatusOK, "application/atom+xml; charset=utf-8", []byte(websubHub.advertise(c, FormatAtom, atom)))
+	case FormatJSONFeed:
+		c.Header("Content-Type", "application/feed+json; charset=utf-8")
+		c.JSON(http.StatusOK, jsonFeed(topic, link, news))
+	default:
+		h := gin.H{}
+		for k, v := range extra {
+			h[k] = v
+		}
+		h["news"] = news
+		c.JSON(http.StatusOK, h)
+	}
+}
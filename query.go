@@ -0,0 +1,233 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const maxQueryLimit = 500
+
+var queryTimeErr = errors.New("since and until must be RFC 3339, 2006-01-02 or unix seconds, an until day includes the whole day")
+var queryLimitErr = errors.New("limit must be a positive number")
+var queryStatusErr = errors.New("status must be a number")
+var queryCursorErr = errors.New("cursor is not valid")
+
+// NewsQuery narrows topic results down after the pipeline. Until is
+// inclusive, unless untilDay made it the exclusive end of a day.
+type NewsQuery struct {
+	Since   time.Time
+	Until   time.Time
+	Limit   int
+	Sources []string
+	Tags    []string
+	Status  []int
+	Q       string
+
+	untilDay   bool
+	cursorTime time.Time
+	cursorID   string
+}
+
+// byTimeID orders items newest first, ties broken by id so that a cursor
+// always points at the same place
+type byTimeID []RssItem
+
+func (a byTimeID) Len() int      { return len(a) }
+func (a byTimeID) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a byTimeID) Less(i, j int) bool {
+	if !a[i].Time.Equal(a[j].Time) {
+		return a[i].Time.After(a[j].Time)
+	}
+	return itemID(a[i]) < itemID(a[j])
+}
+
+func splitQuery(value string) []string {
+	collect := []string{}
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			collect = append(collect, v)
+		}
+	}
+	return collect
+}
+
+// parseQueryTime accepts RFC 3339, a Taipei day or unix seconds, and
+// tells whether the value was a day
+func parseQueryTime(value string) (time.Time, bool, error) {
+	if value == "" {
+		return time.Time{}, false, nil
+	}
+	if t, parseErr := time.Parse(time.RFC3339, value); parseErr == nil {
+		return t, false, nil
+	}
+	location, loadLocationErr := time.LoadLocation(timeZone)
+	if loadLocationErr != nil {
+		location = time.UTC
+	}
+	if t, parseErr := time.ParseInLocation(archiveDateFormat, value, location); parseErr == nil {
+		return t, true, nil
+	}
+	if seconds, parseErr := strconv.ParseInt(value, 10, 64); parseErr == nil {
+		return time.Unix(seconds, 0), false, nil
+	}
+	return time.Time{}, false, queryTimeErr
+}
+
+// encodeCursor points after the given item
+func encodeCursor(item RssItem) string {
+	key := strconv.FormatInt(item.Time.UnixNano(), 10) + ":" + itemID(item)
+	return base64.RawURLEncoding.EncodeToString([]byte(key))
+}
+
+func decodeCursor(cursor string) (time.Time, string, error) {
+	data, decodeErr := base64.RawURLEncoding.DecodeString(cursor)
+	if decodeErr != nil {
+		return time.Time{}, "", queryCursorErr
+	}
+	parts := strings.SplitN(string(data), ":", 2)
+	if len(parts) != 2 {
+		return time.Time{}, "", queryCursorErr
+	}
+	nano, parseErr := strconv.ParseInt(parts[0], 10, 64)
+	if parseErr != nil {
+		return time.Time{}, "", queryCursorErr
+	}
+	return time.Unix(0, nano), parts[1], nil
+}
+
+// ParseNewsQuery reads since, until, limit, cursor, source, tag, status
+// and q from the request. An until day such as 2017-07-01 keeps the
+// items of that whole day.
+func ParseNewsQuery(c *gin.Context) (NewsQuery, error) {
+	return parseNewsValues(c.Request.URL.Query())
+}
+
+// parseNewsValues reads a query from url values, such as the ones of a
+// feed url
+func parseNewsValues(values url.Values) (NewsQuery, error) {
+	query := NewsQuery{
+		Sources: splitQuery(values.Get("source")),
+		Tags:    splitQuery(values.Get("tag")),
+		Q:       strings.ToLower(strings.TrimSpace(values.Get("q"))),
+	}
+
+	var timeErr error
+	if query.Since, _, timeErr = parseQueryTime(values.Get("since")); timeErr != nil {
+		return query, timeErr
+	}
+	if query.Until, query.untilDay, timeErr = parseQueryTime(values.Get("until")); timeErr != nil {
+		return query, timeErr
+	}
+	if query.untilDay {
+		query.Until = query.Until.AddDate(0, 0, 1)
+	}
+
+	if limit := values.Get("limit"); limit != "" {
+		n, parseErr := strconv.Atoi(limit)
+		if parseErr != nil || n <= 0 {
+			return query, queryLimitErr
+		}
+		if n > maxQueryLimit {
+			n = maxQueryLimit
+		}
+		query.Limit = n
+	}
+
+	for _, status := range splitQuery(values.Get("status")) {
+		n, parseErr := strconv.Atoi(status)
+		if parseErr != nil {
+			return query, queryStatusErr
+		}
+		query.Status = append(query.Status, n)
+	}
+
+	if cursor := values.Get("cursor"); cursor != "" {
+		var cursorErr error
+		query.cursorTime, query.cursorID, cursorErr = decodeCursor(cursor)
+		if cursorErr != nil {
+			return query, cursorErr
+		}
+	}
+
+	return query, nil
+}
+
+func containsString(list []string, value string) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func containsInt(list []int, value int) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// Match reports whether an item passes the filters, the cursor aside
+func (q NewsQuery) Match(item RssItem) bool {
+	if !q.Since.IsZero() && !item.Time.After(q.Since) {
+		return false
+	}
+	if !q.Until.IsZero() && item.Time.After(q.Until) {
+		return false
+	}
+	if q.untilDay && !item.Time.Before(q.Until) {
+		return false
+	}
+	if len(q.Sources) > 0 && !containsString(q.Sources, item.Source) {
+		return false
+	}
+	if len(q.Tags) > 0 && !containsString(q.Tags, item.Tag) {
+		return false
+	}
+	if len(q.Status) > 0 && !containsInt(q.Status, item.Status) {
+		return false
+	}
+	if q.Q != "" && !strings.Contains(strings.ToLower(item.Title+" "+item.Description), q.Q) {
+		return false
+	}
+	return true
+}
+
+// afterCursor reports whether an item comes after the cursor
+func (q NewsQuery) afterCursor(item RssItem) bool {
+	if q.cursorID == "" {
+		return true
+	}
+	if !item.Time.Equal(q.cursorTime) {
+		return item.Time.Before(q.cursorTime)
+	}
+	return itemID(item) > q.cursorID
+}
+
+// Apply filters news and returns one page of it, with the cursor of the
+// next page or "" on the last page
+func (q NewsQuery) Apply(news []RssItem) ([]RssItem, string) {
+	collect := []RssItem{}
+	for _, item := range news {
+		if q.Match(item) && q.afterCursor(item) {
+			collect = append(collect, item)
+		}
+	}
+	sort.Sort(byTimeID(collect))
+
+	if q.Limit > 0 && len(collect) > q.Limit {
+		collect = collect[:q.Limit]
+		return collect, encodeCursor(collect[len(collect)-1])
+	}
+	return collect, ""
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestNewsQueryUntilDay(t *testing.T) {
+	location, _ := time.LoadLocation(timeZone)
+	july1 := time.Date(2017, 7, 1, 0, 0, 0, 0, location)
+
+	query, queryErr := parseNewsValues(url.Values{"until": {"2017-07-01"}})
+	if queryErr != nil {
+		t.Fatal(queryErr)
+	}
+	// a day keeps the whole of it and nothing after
+	for _, c := range []struct {
+		time  time.Time
+		match bool
+	}{
+		{july1.Add(-time.Minute), true},
+		{july1, true},
+		{july1.Add(23*time.Hour + 59*time.Minute), true},
+		{july1.AddDate(0, 0, 1), false},
+	} {
+		if got := query.Match(RssItem{Time: c.time}); got != c.match {
+			t.Errorf("until day matches %v = %v, want %v", c.time, got, c.match)
+		}
+	}
+
+	// a timestamp stays inclusive
+	query, _ = parseNewsValues(url.Values{"until": {"2017-07-01T00:00:00+08:00"}})
+	if !query.Match(RssItem{Time: july1}) || query.Match(RssItem{Time: july1.Add(time.Second)}) {
+		t.Error("until timestamp is not inclusive")
+	}
+}
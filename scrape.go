@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"hash/fnv"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// SourceScrape reads sites without feeds with CSS selectors
+const SourceScrape = "scrape"
+
+var scrapeItemErr = errors.New("scrape source needs an item selector")
+var scrapeDateErr = errors.New("scrape source needs a date selector")
+
+// leading year of a date in the Republic of China calendar, as used by
+// government sites
+var rocYear = regexp.MustCompile(`^(\d{2,3})([-/.年])`)
+
+func init() {
+	RegisterSource(SourceScrape, func(conf SourceConfig) (Source, error) {
+		if conf.URL == "" {
+			return nil, sourceURLErr
+		}
+		if conf.Options["item"] == "" {
+			return nil, scrapeItemErr
+		}
+		// without dates items would look new on every fetch
+		if conf.Options["date"] == "" {
+			return nil, scrapeDateErr
+		}
+		return &ScrapeSource{
+			Tag:        conf.Name,
+			URL:        conf.URL,
+			Base:       conf.Options["base"],
+			Item:       conf.Options["item"],
+			Title:      conf.Options["title"],
+			Link:       conf.Options["link"],
+			Date:       conf.Options["date"],
+			Summary:    conf.Options["summary"],
+			DateLayout: conf.Options["dateLayout"],
+			ROCYear:    conf.Options["calendar"] == "roc",
+			Source:     conf.Options["source"],
+		}, nil
+	})
+}
+
+// ScrapeSource reads the items of a list page. Item selects every item,
+// the other selectors apply inside an item and take "selector@attr" to
+// read an attribute instead of the text, an empty selector being the
+// item itself. URL may be a local file, Base then resolves relative
+// links.
+type ScrapeSource struct {
+	Tag        string
+	URL        string
+	Base       string
+	Item       string
+	Title      string
+	Link       string
+	Date       string
+	Summary    string
+	DateLayout string
+	ROCYear    bool
+	Source     string
+}
+
+// scrapeValue reads the text or an attribute of what a selector matches
+func scrapeValue(s *goquery.Selection, selector string) string {
+	attr := ""
+	if i := strings.LastIndex(selector, "@"); i >= 0 {
+		selector, attr = selector[:i], selector[i+1:]
+	}
+	if strings.TrimSpace(selector) != "" {
+		s = s.Find(selector).First()
+	}
+
+	if attr != "" {
+		value, _ := s.Attr(attr)
+		return strings.TrimSpace(value)
+	}
+	return strings.Join(strings.Fields(s.Text()), " ")
+}
+
+// scrapeTime parses a date with the configured layout, or else with the
+// layouts feeds use
+func (s *ScrapeSource) scrapeTime(text string) time.Time {
+	if s.ROCYear {
+		if m := rocYear.FindStringSubmatch(text); m != nil {
+			year, _ := strconv.Atoi(m[1])
+			text = strconv.Itoa(year+1911) + m[2] + text[len(m[0]):]
+		}
+	}
+
+	if s.DateLayout == "" {
+		return loadLocal(text, s.Tag)
+	}
+
+	location, loadLocationErr := time.LoadLocation(timeZone)
+	if loadLocationErr != nil {
+		location = time.UTC
+	}
+	local, parseErr := time.ParseInLocation(s.DateLayout, text, location)
+	if parseErr != nil {
+		return loadLocal(text, s.Tag)
+	}
+	return local
+}
+
+// Parse reads the items of a list page
+func (s *ScrapeSource) Parse(data []byte) ([]RssItem, error) {
+	doc, docErr := goquery.NewDocumentFromReader(bytes.NewReader(data))
+	if docErr != nil {
+		return nil, docErr
+	}
+
+	base, baseErr := url.Parse(s.URL)
+	if s.Base != "" {
+		base, baseErr = url.Parse(s.Base)
+	}
+
+	linkSelector := s.Link
+	if linkSelector == "" {
+		linkSelector = "a@href"
+	}
+
+	collect := []RssItem{}
+	doc.Find(s.Item).Each(func(i int, item *goquery.Selection) {
+		title := scrapeValue(item, s.Title)
+		link := scrapeValue(item, linkSelector)
+		if title == "" || link == "" {
+			return
+		}
+		if baseErr == nil {
+			if ref, refErr := url.Parse(link); refErr == nil {
+				link = base.ResolveReference(ref).String()
+			}
+		}
+
+		local := s.scrapeTime(scrapeValue(item, s.Date))
+
+		summary := ""
+		if s.Summary != "" {
+			summary = scrapeValue(item, s.Summary)
+		}
+
+		h := fnv.New32a()
+		h.Write([]byte(title))
+
+		shortLink, originLink, getURLErr := GetURL(link)
+		if getURLErr != nil {
+			return
+		}
+		source, keyword := GetNewsSource(link)
+		if keyword == "" && s.Source != "" {
+			source = s.Source
+		}
+
+		collect = append(collect, RssItem{
+			Link:        shortLink,
+			OriginLink:  originLink,
+			Time:        local,
+			TimeText:    local.Format("15:04"),
+			Title:       title,
+			Source:      source,
+			Tag:         s.Tag,
+			Hash:        h.Sum32(),
+			Keyword:     keyword,
+			Description: summary,
+		})
+	})
+
+	return collect, nil
+}
+
+// Fetch implements Source
+func (s *ScrapeSource) Fetch(ctx context.Context) (SourceResult, error) {
+	items, fetchErr := runSource(ctx, func() ([]RssItem, error) {
+		data, reportErr := fetchReport(s.URL)
+		if reportErr != nil {
+			return nil, reportErr
+		}
+		return s.Parse(data)
+	})
+	return SourceResult{
+		Items: items,
+		Meta:  SourceMeta{Type: SourceScrape, Name: s.Tag, URL: s.URL},
+	}, fetchErr
+}
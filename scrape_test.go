@@ -0,0 +1,99 @@
+package main
+
+import (
+	"io/ioutil"
+	"testing"
+)
+
+func scrapeFixtureSource() *ScrapeSource {
+	return &ScrapeSource{
+		Tag:        "新竹市消防局",
+		URL:        "testdata/scrape_news.html",
+		Base:       "https://fire.hccg.gov.tw/ch/",
+		Item:       "tr.item",
+		Title:      "td.title a",
+		Link:       "td.title a@href",
+		Date:       "td.date",
+		Summary:    "td.unit",
+		DateLayout: "2006/01/02",
+		ROCYear:    true,
+		Source:     "新竹市消防局",
+	}
+}
+
+func TestScrapeSourceParse(t *testing.T) {
+	data, readErr := ioutil.ReadFile("testdata/scrape_news.html")
+	if readErr != nil {
+		t.Fatal(readErr)
+	}
+
+	items, parseErr := scrapeFixtureSource().Parse(data)
+	if parseErr != nil {
+		t.Fatal(parseErr)
+	}
+	// the row without a title is skipped
+	if len(items) != 3 {
+		t.Fatalf("got %d items, want 3", len(items))
+	}
+
+	item := items[0]
+	if item.Title != "花蓮地震 本局派遣搜救隊支援" {
+		t.Errorf("Title = %q", item.Title)
+	}
+	if item.OriginLink != "https://fire.hccg.gov.tw/ch/News_Content.aspx?n=2718&s=120431" {
+		t.Errorf("OriginLink = %v", item.OriginLink)
+	}
+	if got := item.Time.Format("2006-01-02"); got != "2018-02-06" {
+		t.Errorf("Time = %v", got)
+	}
+	if item.Source != "新竹市政府" || item.Tag != "新竹市消防局" || item.Description != "災害搶救科" {
+		t.Errorf("Source, Tag or Description = %q %q %q", item.Source, item.Tag, item.Description)
+	}
+
+	// text is collapsed and root-relative links resolve against the host
+	if items[1].Title != "春節期間 防火宣導" || items[1].OriginLink != "https://fire.hccg.gov.tw/News_Content.aspx?n=2718&s=120398" {
+		t.Errorf("second item = %q %v", items[1].Title, items[1].OriginLink)
+	}
+
+	// links to unknown sites take the configured source name
+	if items[2].Source != "新竹市消防局" || items[2].OriginLink != "https://www.nfa.gov.tw/cht/index.php?code=list&ids=220" {
+		t.Errorf("third item = %q %v", items[2].Source, items[2].OriginLink)
+	}
+}
+
+func TestScrapeSourceParseTwice(t *testing.T) {
+	data, readErr := ioutil.ReadFile("testdata/scrape_news.html")
+	if readErr != nil {
+		t.Fatal(readErr)
+	}
+
+	first, _ := scrapeFixtureSource().Parse(data)
+	second, _ := scrapeFixtureSource().Parse(data)
+	for i := range first {
+		if !first[i].Time.Equal(second[i].Time) {
+			t.Errorf("item %d is dated %v, then %v", i, first[i].Time, second[i].Time)
+		}
+	}
+}
+
+func TestScrapeSourceNeedsDate(t *testing.T) {
+	_, sourceErr := NewSource(SourceConfig{
+		Type:    SourceScrape,
+		Name:    "新竹市消防局",
+		URL:     "testdata/scrape_news.html",
+		Options: map[string]string{"item": "tr.item"},
+	})
+	if sourceErr != scrapeDateErr {
+		t.Errorf("error = %v, want %v", sourceErr, scrapeDateErr)
+	}
+
+	_, sourceErr = NewSource(SourceConfig{
+		Type:    SourceScrape,
+		Name:    "新竹市消防局",
+		URL:     "testdata/scrape_news.html",
+		Options: map[string]string{"item": "tr.item", "date": "td.date", "calendar": "roc"},
+	})
+	if sourceErr != nil {
+		t.Error(sourceErr)
+	}
+}
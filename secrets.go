@@ -0,0 +1,326 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Secret names, also the file names in a secrets directory and, upper
+// cased with a FIRENEWS_ prefix, the environment variables
+const (
+	SecretGoogleAPIKey        = "google_api_key"
+	SecretFacebookAppID       = "facebook_app_id"
+	SecretFacebookAppSecret   = "facebook_app_secret"
+	SecretFacebookVerifyToken = "facebook_verify_token"
+	SecretCWBAuthorization    = "cwb_authorization"
+)
+
+// Integrations that depend on secrets
+const (
+	IntegrationShortener       = "shortener"
+	IntegrationFacebook        = "facebook"
+	IntegrationFacebookWebhook = "facebook_webhook"
+	IntegrationEarthquake      = "earthquake"
+)
+
+const defaultSecretsKeyEnv = "FIRENEWS_SECRETS_KEY"
+
+var secretsKeyErr = errors.New("secrets key must be 32 bytes, hex or base64 encoded")
+var secretsCipherErr = errors.New("encrypted secrets file is too short")
+
+var integrationSecrets = map[string][]string{
+	IntegrationShortener:       {SecretGoogleAPIKey},
+	IntegrationFacebook:        {SecretFacebookAppID, SecretFacebookAppSecret},
+	IntegrationFacebookWebhook: {SecretFacebookAppSecret, SecretFacebookVerifyToken},
+	IntegrationEarthquake:      {SecretCWBAuthorization},
+}
+
+var secretFormats = map[string]*regexp.Regexp{
+	SecretGoogleAPIKey:        regexp.MustCompile(`^[0-9A-Za-z_\-]{20,}$`),
+	SecretFacebookAppID:       regexp.MustCompile(`^[0-9]+$`),
+	SecretFacebookAppSecret:   regexp.MustCompile(`^[0-9a-f]{32}$`),
+	SecretFacebookVerifyToken: regexp.MustCompile(`^[\x21-\x7e]{8,}$`),
+	SecretCWBAuthorization:    regexp.MustCompile(`^CW[AB]-[0-9A-Za-z-]{20,}$`),
+}
+
+// CredentialsConfig struct
+type CredentialsConfig struct {
+	SecretsFile   string `json:"secretsFile"`
+	EncryptedFile string `json:"encryptedFile"`
+	KeyEnv        string `json:"keyEnv"`
+}
+
+// IntegrationStatus is reported by the health endpoint
+type IntegrationStatus struct {
+	Name    string `json:"name"`
+	Enabled bool   `json:"enabled"`
+	Reason  string `json:"reason,omitempty"`
+}
+
+// Credentials reads secrets from the environment, a mounted secrets file
+// or directory, and an encrypted file, in that order of precedence
+type Credentials struct {
+	sync.RWMutex
+	conf     CredentialsConfig
+	values   map[string]string
+	sources  map[string]string
+	problems map[string]string
+	loadedAt time.Time
+}
+
+var credentials = NewCredentials(CredentialsConfig{})
+
+// NewCredentials creates a provider, call Reload to read the secrets
+func NewCredentials(conf CredentialsConfig) *Credentials {
+	if conf.KeyEnv == "" {
+		conf.KeyEnv = defaultSecretsKeyEnv
+	}
+	return &Credentials{
+		conf:     conf,
+		values:   make(map[string]string),
+		sources:  make(map[string]string),
+		problems: make(map[string]string),
+	}
+}
+
+func secretEnv(name string) string {
+	return "FIRENEWS_" + strings.ToUpper(name)
+}
+
+// readSecretsFile reads a JSON object of secrets, or a directory holding
+// one file per secret as container orchestrators mount them
+func readSecretsFile(path string) (map[string]string, error) {
+	values := make(map[string]string)
+
+	info, statErr := os.Stat(path)
+	if statErr != nil {
+		return values, statErr
+	}
+
+	if info.IsDir() {
+		for name := range secretFormats {
+			data, readErr := ioutil.ReadFile(filepath.Join(path, name))
+			if readErr == nil {
+				values[name] = strings.TrimSpace(string(data))
+			}
+		}
+		return values, nil
+	}
+
+	data, readErr := ioutil.ReadFile(path)
+	if readErr != nil {
+		return values, readErr
+	}
+	return values, json.Unmarshal(data, &values)
+}
+
+func decodeSecretsKey(text string) ([]byte, error) {
+	text = strings.TrimSpace(text)
+	if key, hexErr := hex.DecodeString(text); hexErr == nil && len(key) == 32 {
+		return key, nil
+	}
+	if key, b64Err := base64.StdEncoding.DecodeString(text); b64Err == nil && len(key) == 32 {
+		return key, nil
+	}
+	return nil, secretsKeyErr
+}
+
+// EncryptSecrets seals a JSON object of secrets with AES-256-GCM, the
+// result is what the encrypted file holds
+func EncryptSecrets(keyText string, plaintext []byte) ([]byte, error) {
+	key, keyErr := decodeSecretsKey(keyText)
+	if keyErr != nil {
+		return nil, keyErr
+	}
+	block, blockErr := aes.NewCipher(key)
+	if blockErr != nil {
+		return nil, blockErr
+	}
+	gcm, gcmErr := cipher.NewGCM(block)
+	if gcmErr != nil {
+		return nil, gcmErr
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, randErr := io.ReadFull(rand.Reader, nonce); randErr != nil {
+		return nil, randErr
+	}
+	sealed := gcm.Seal(nonce, nonce, plaintext, nil)
+
+	return []byte(base64.StdEncoding.EncodeToString(sealed) + "\n"), nil
+}
+
+// readEncryptedFile opens a file written by EncryptSecrets
+func readEncryptedFile(path string, keyText string) (map[string]string, error) {
+	values := make(map[string]string)
+
+	data, readErr := ioutil.ReadFile(path)
+	if readErr != nil {
+		return values, readErr
+	}
+	key, keyErr := decodeSecretsKey(keyText)
+	if keyErr != nil {
+		return values, keyErr
+	}
+	sealed, decodeErr := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+	if decodeErr != nil {
+		return values, decodeErr
+	}
+
+	block, blockErr := aes.NewCipher(key)
+	if blockErr != nil {
+		return values, blockErr
+	}
+	gcm, gcmErr := cipher.NewGCM(block)
+	if gcmErr != nil {
+		return values, gcmErr
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return values, secretsCipherErr
+	}
+
+	plaintext, openErr := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], nil)
+	if openErr != nil {
+		return values, openErr
+	}
+	return values, json.Unmarshal(plaintext, &values)
+}
+
+// Reload reads every source again and validates the secrets, so that
+// rotated secrets are picked up without a restart
+func (c *Credentials) Reload() {
+	values := make(map[string]string)
+	sources := make(map[string]string)
+	problems := make(map[string]string)
+
+	merge := func(source string, found map[string]string) {
+		for name, value := range found {
+			if _, known := secretFormats[name]; !known || value == "" {
+				continue
+			}
+			if _, set := values[name]; !set {
+				values[name] = value
+				sources[name] = source
+			}
+		}
+	}
+
+	env := make(map[string]string)
+	for name := range secretFormats {
+		if value := strings.TrimSpace(os.Getenv(secretEnv(name))); value != "" {
+			env[name] = value
+		}
+	}
+	merge("env", env)
+
+	if c.conf.SecretsFile != "" {
+		found, readErr := readSecretsFile(c.conf.SecretsFile)
+		if readErr != nil && !os.IsNotExist(readErr) {
+			log.Printf("Credentials readSecretsFile error: %v", readErr)
+		}
+		merge("file", found)
+	}
+
+	if c.conf.EncryptedFile != "" {
+		found, readErr := readEncryptedFile(c.conf.EncryptedFile, os.Getenv(c.conf.KeyEnv))
+		if readErr != nil && !os.IsNotExist(readErr) {
+			log.Printf("Credentials readEncryptedFile error: %v", readErr)
+		}
+		merge("encrypted", found)
+	}
+
+	for name, format := range secretFormats {
+		value, found := values[name]
+		switch {
+		case !found:
+			problems[name] = name + " is missing"
+		case !format.MatchString(value):
+			problems[name] = name + " from " + sources[name] + " is malformed"
+			delete(values, name)
+		}
+	}
+
+	c.Lock()
+	c.values = values
+	c.sources = sources
+	c.problems = problems
+	c.loadedAt = time.Now()
+	c.Unlock()
+
+	for _, status := range c.Integrations() {
+		if !status.Enabled {
+			log.Println("integration disabled:", status.Name, "-", status.Reason)
+		}
+	}
+}
+
+// Get returns a secret, or "" when it is missing or malformed
+func (c *Credentials) Get(name string) string {
+	c.RLock()
+	defer c.RUnlock()
+	return c.values[name]
+}
+
+// Integration reports whether an integration has every secret it needs
+func (c *Credentials) Integration(name string) IntegrationStatus {
+	c.RLock()
+	defer c.RUnlock()
+
+	status := IntegrationStatus{Name: name, Enabled: true}
+	reasons := []string{}
+	for _, secret := range integrationSecrets[name] {
+		if problem, found := c.problems[secret]; found {
+			reasons = append(reasons, problem)
+		}
+	}
+	if len(reasons) > 0 {
+		status.Enabled = false
+		status.Reason = strings.Join(reasons, ", ")
+	}
+	return status
+}
+
+// Integrations lists the status of every integration
+func (c *Credentials) Integrations() []IntegrationStatus {
+	return []IntegrationStatus{
+		c.Integration(IntegrationShortener),
+		c.Integration(IntegrationFacebook),
+		c.Integration(IntegrationFacebookWebhook),
+		c.Integration(IntegrationEarthquake),
+	}
+}
+
+// LoadedAt returns when the secrets were last read
+func (c *Credentials) LoadedAt() time.Time {
+	c.RLock()
+	defer c.RUnlock()
+	return c.loadedAt
+}
+
+// ReloadOnSignal reloads the secrets on SIGHUP
+func (c *Credentials) ReloadOnSignal() {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			log.Println("reloading credentials")
+			c.Reload()
+		}
+	}()
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/xml"
+	"hash/fnv"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SourceSitemap reads Google News sitemaps and sitemap indexes
+const SourceSitemap = "sitemap"
+
+const (
+	defaultSitemapMaxAge   = 48
+	defaultSitemapChildren = 10
+	sitemapMaxDepth        = 2
+	sitemapMaxBytes        = 50 << 20
+)
+
+var sitemapDateLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04Z07:00",
+	"2006-01-02T15:04:05",
+	"2006-01-02",
+}
+
+// sitemapDoc is either a sitemap index or an urlset, elements are
+// matched by local name whatever their namespace
+type sitemapDoc struct {
+	XMLName  xml.Name
+	Sitemaps []struct {
+		Loc     string `xml:"loc"`
+		LastMod string `xml:"lastmod"`
+	} `xml:"sitemap"`
+	URLs []struct {
+		Loc  string `xml:"loc"`
+		News struct {
+			Publication struct {
+				Name     string `xml:"name"`
+				Language string `xml:"language"`
+			} `xml:"publication"`
+			PublicationDate string `xml:"publication_date"`
+			Title           string `xml:"title"`
+			Keywords        string `xml:"keywords"`
+		} `xml:"news"`
+	} `xml:"url"`
+}
+
+func init() {
+	RegisterSource(SourceSitemap, func(conf SourceConfig) (Source, error) {
+		if conf.URL == "" {
+			return nil, sourceURLErr
+		}
+		source := &SitemapSource{
+			Tag:         conf.Name,
+			URL:         conf.URL,
+			MaxAge:      defaultSitemapMaxAge,
+			MaxChildren: defaultSitemapChildren,
+		}
+		if hours, parseErr := strconv.Atoi(conf.Options["maxAge"]); parseErr == nil && hours > 0 {
+			source.MaxAge = hours
+		}
+		if children, parseErr := strconv.Atoi(conf.Options["maxSitemaps"]); parseErr == nil && children > 0 {
+			source.MaxChildren = children
+		}
+		return source, nil
+	})
+}
+
+// SitemapSource reads the news entries of a news sitemap, or of the
+// latest sitemaps of an index, published in the last MaxAge hours
+type SitemapSource struct {
+	Tag         string
+	URL         string
+	MaxAge      int
+	MaxChildren int
+}
+
+// sitemapTime parses a W3C datetime, dates without a zone are local
+func sitemapTime(text string) (time.Time, bool) {
+	text = strings.TrimSpace(text)
+	location, loadLocationErr := time.LoadLocation(timeZone)
+	if loadLocationErr != nil {
+		location = time.UTC
+	}
+	for _, layout := range sitemapDateLayouts {
+		if local, parseErr := time.ParseInLocation(layout, text, location); parseErr == nil {
+			return local.In(location), true
+		}
+	}
+	return time.Time{}, false
+}
+
+// readSitemap fetches a sitemap, gzipped or not. Only a local sitemap
+// may point at local files, remote ones go through the outbound url
+// policy.
+func readSitemap(loc string, local bool) (*sitemapDoc, error) {
+	var data []byte
+	var fetchErr error
+	if local {
+		data, fetchErr = fetchReport(loc)
+	} else {
+		data, fetchErr = egress.Fetch(loc, 30*time.Second)
+	}
+	if fetchErr != nil {
+		return nil, fetchErr
+	}
+
+	if bytes.HasPrefix(data, []byte{0x1f, 0x8b}) {
+		reader, gzipErr := gzip.NewReader(bytes.NewReader(data))
+		if gzipErr != nil {
+			return nil, gzipErr
+		}
+		data, fetchErr = ioutil.ReadAll(io.LimitReader(reader, sitemapMaxBytes))
+		if fetchErr != nil {
+			return nil, fetchErr
+		}
+	}
+
+	doc := &sitemapDoc{}
+	if xmlErr := xml.Unmarshal(data, doc); xmlErr != nil {
+		return nil, xmlErr
+	}
+	return doc, nil
+}
+
+type sitemapChild struct {
+	Loc     string
+	LastMod time.Time
+}
+
+type byLastMod []sitemapChild
+
+func (a byLastMod) Len() int           { return len(a) }
+func (a byLastMod) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a byLastMod) Less(i, j int) bool { return a[i].LastMod.After(a[j].LastMod) }
+
+// entries reads a sitemap and, for an index, its latest sitemaps
+func (s *SitemapSource) entries(loc string, local bool, depth int, oldest time.Time) ([]RssItem, error) {
+	doc, readErr := readSitemap(loc, local)
+	if readErr != nil {
+		return nil, readErr
+	}
+
+	if doc.XMLName.Local == "sitemapindex" {
+		if depth >= sitemapMaxDepth {
+			return []RssItem{}, nil
+		}
+
+		children := []sitemapChild{}
+		for _, sitemap := range doc.Sitemaps {
+			lastMod, found := sitemapTime(sitemap.LastMod)
+			if found && lastMod.Before(oldest) {
+				continue
+			}
+			children = append(children, sitemapChild{Loc: strings.TrimSpace(sitemap.Loc), LastMod: lastMod})
+		}
+		sort.Stable(byLastMod(children))
+		if len(children) > s.MaxChildren {
+			children = children[:s.MaxChildren]
+		}
+
+		var mutex sync.Mutex
+		var wg sync.WaitGroup
+		collect := []RssItem{}
+		wg.Add(len(children))
+		for _, child := range children {
+			go func(child sitemapChild) {
+				defer wg.Done()
+				items, childErr := s.entries(child.Loc, local, depth+1, oldest)
+				if childErr != nil {
+					return
+				}
+				mutex.Lock()
+				collect = append(collect, items...)
+				mutex.Unlock()
+			}(child)
+		}
+		wg.Wait()
+		return collect, nil
+	}
+
+	collect := []RssItem{}
+	for _, entry := range doc.URLs {
+		link := strings.TrimSpace(entry.Loc)
+		title := strings.TrimSpace(entry.News.Title)
+		if link == "" || title == "" {
+			continue
+		}
+		local, found := sitemapTime(entry.News.PublicationDate)
+		if !found || local.Before(oldest) {
+			continue
+		}
+
+		h := fnv.New32a()
+		h.Write([]byte(title))
+
+		shortLink, originLink, getURLErr := GetURL(link)
+		if getURLErr != nil {
+			continue
+		}
+		source, keyword := GetNewsSource(link)
+		if keyword == "" && entry.News.Publication.Name != "" {
+			source = strings.TrimSpace(entry.News.Publication.Name)
+		}
+
+		collect = append(collect, RssItem{
+			Link:        shortLink,
+			OriginLink:  originLink,
+			Time:        local,
+			TimeText:    local.Format("15:04"),
+			Title:       title,
+			Source:      source,
+			Tag:         s.Tag,
+			Hash:        h.Sum32(),
+			Keyword:     keyword,
+			Description: strings.TrimSpace(entry.News.Keywords),
+		})
+	}
+	return collect, nil
+}
+
+// Fetch implements Source, the include pattern also sees the keywords
+// which are kept as the description
+func (s *SitemapSource) Fetch(ctx context.Context) (SourceResult, error) {
+	items, fetchErr := runSource(ctx, func() ([]RssItem, error) {
+		local := !strings.HasPrefix(s.URL, "http://") && !strings.HasPrefix(s.URL, "https://")
+		oldest := time.Now().Add(-time.Duration(s.MaxAge) * time.Hour)
+		return s.entries(s.URL, local, 0, oldest)
+	})
+	return SourceResult{
+		Items: items,
+		Meta:  SourceMeta{Type: SourceSitemap, Name: s.Tag, URL: s.URL},
+	}, fetchErr
+}
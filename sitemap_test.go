@@ -0,0 +1,79 @@
+package main
+
+import (
+	"sort"
+	"testing"
+	"time"
+)
+
+// sitemapOldest is the cut-off of the fixtures, a day before their news
+var sitemapOldest = time.Date(2018, 2, 5, 0, 0, 0, 0, time.FixedZone("CST", 8*3600))
+
+func TestSitemapSourceIndex(t *testing.T) {
+	source := &SitemapSource{Tag: "範例", URL: "testdata/sitemap_index.xml", MaxChildren: defaultSitemapChildren}
+	items, entriesErr := source.entries(source.URL, true, 0, sitemapOldest)
+	if entriesErr != nil {
+		t.Fatal(entriesErr)
+	}
+	// the sitemap last modified before the cut-off is not read, entries
+	// without a date or older than the cut-off are dropped
+	if len(items) != 2 {
+		t.Fatalf("got %d items, want 2: %+v", len(items), items)
+	}
+	sort.Sort(ByTime(items))
+
+	item := items[0]
+	if item.Title != "花蓮強震 新竹市消防局派員支援" || item.Tag != "範例" {
+		t.Errorf("Title or Tag = %q %q", item.Title, item.Tag)
+	}
+	if want := time.Date(2018, 2, 6, 15, 50, 0, 0, time.UTC); !item.Time.Equal(want) || item.TimeText != "23:50" {
+		t.Errorf("Time = %v %q, want %v", item.Time, item.TimeText, want)
+	}
+	if item.OriginLink != "https://www.example-news.com.tw/news/20180206/1101.html" {
+		t.Errorf("OriginLink = %v", item.OriginLink)
+	}
+	// unknown sites take the publication name
+	if item.Source != "範例新聞網" || item.Keyword != "" {
+		t.Errorf("Source or Keyword = %q %q", item.Source, item.Keyword)
+	}
+	if item.Description != "地震, 花蓮, 消防" {
+		t.Errorf("Description = %q", item.Description)
+	}
+
+	// known sites keep their own name, dates without a zone are local
+	item = items[1]
+	if item.Source != "勁報" || item.Keyword != "twpowernews.com" {
+		t.Errorf("Source or Keyword = %q %q", item.Source, item.Keyword)
+	}
+	if want := time.Date(2018, 2, 6, 1, 30, 0, 0, time.UTC); !item.Time.Equal(want) {
+		t.Errorf("Time = %v, want %v", item.Time, want)
+	}
+}
+
+func TestSitemapSourceDepth(t *testing.T) {
+	// an index below the depth limit is not followed
+	source := &SitemapSource{Tag: "範例", URL: "testdata/sitemap_index.xml", MaxChildren: defaultSitemapChildren}
+	items, entriesErr := source.entries(source.URL, true, sitemapMaxDepth, sitemapOldest)
+	if entriesErr != nil || len(items) != 0 {
+		t.Errorf("got %d items and %v, want none", len(items), entriesErr)
+	}
+}
+
+func TestSitemapTime(t *testing.T) {
+	cases := map[string]string{
+		"2018-02-06T23:50:00+08:00": "2018-02-06T15:50:00Z",
+		"2018-02-06T15:50:00.5Z":    "2018-02-06T15:50:00.5Z",
+		"2018-02-06T23:50+08:00":    "2018-02-06T15:50:00Z",
+		"2018-02-06T23:50:00":       "2018-02-06T15:50:00Z",
+		" 2018-02-06 ":              "2018-02-05T16:00:00Z",
+	}
+	for text, want := range cases {
+		local, found := sitemapTime(text)
+		if !found || local.UTC().Format(time.RFC3339Nano) != want {
+			t.Errorf("sitemapTime(%q) = %v %v, want %v", text, local, found, want)
+		}
+	}
+	if _, found := sitemapTime("6 Feb 2018"); found {
+		t.Error("an unknown layout parsed")
+	}
+}
@@ -0,0 +1,416 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"hash/fnv"
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Source types
+const (
+	SourceRSS      = "rss"
+	SourceCAP      = "cap"
+	SourceFacebook = "facebook"
+)
+
+const (
+	sourceTimeout        = 2 * time.Minute
+	sourceTitleMaxLength = 80
+)
+
+var sourceTypeErr = errors.New("unknown source type")
+var sourceURLErr = errors.New("source needs a url")
+var sourceIDErr = errors.New("source needs an id")
+
+// SourceConfig configures a source, Type selects the adapter and Name is
+// the tag of its items. Include is an optional pattern the title or
+// description must match, Options holds settings of a single adapter.
+type SourceConfig struct {
+	Type    string            `json:"type"`
+	Name    string            `json:"name"`
+	URL     string            `json:"url"`
+	ID      string            `json:"id"`
+	Include string            `json:"include"`
+	Options map[string]string `json:"options"`
+}
+
+// SourceMeta describes a fetch
+type SourceMeta struct {
+	Type     string    `json:"type"`
+	Name     string    `json:"name"`
+	URL      string    `json:"url,omitempty"`
+	Count    int       `json:"count"`
+	Fetched  time.Time `json:"fetched"`
+	Duration int64     `json:"durationMs"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// SourceResult is what a source returns, items are normalized to RssItem
+type SourceResult struct {
+	Items []RssItem
+	Meta  SourceMeta
+}
+
+// Source is a place items are read from
+type Source interface {
+	Fetch(ctx context.Context) (SourceResult, error)
+}
+
+// SourceFactory builds a source from its configuration
+type SourceFactory func(conf SourceConfig) (Source, error)
+
+var sourceTypes = map[string]SourceFactory{}
+
+// RegisterSource adds a source type selectable from the configuration
+func RegisterSource(kind string, factory SourceFactory) {
+	sourceTypes[kind] = factory
+}
+
+// NewSource builds a source of the configured type
+func NewSource(conf SourceConfig) (Source, error) {
+	factory, found := sourceTypes[conf.Type]
+	if !found {
+		return nil, sourceTypeErr
+	}
+	source, sourceErr := factory(conf)
+	if sourceErr != nil {
+		return nil, sourceErr
+	}
+	if conf.Include != "" {
+		rp, regexpErr := regexp.Compile(conf.Include)
+		if regexpErr != nil {
+			return nil, regexpErr
+		}
+		source = includeSource{source: source, rp: rp}
+	}
+	return source, nil
+}
+
+func init() {
+	RegisterSource(SourceRSS, func(conf SourceConfig) (Source, error) {
+		if conf.URL == "" {
+			return nil, sourceURLErr
+		}
+		return &RSSSource{Tag: conf.Name, URL: conf.URL}, nil
+	})
+	RegisterSource(SourceCAP, func(conf SourceConfig) (Source, error) {
+		if conf.URL == "" {
+			return nil, sourceURLErr
+		}
+		return &CAPSource{Tag: conf.Name, URL: conf.URL, Jurisdiction: config.Jurisdiction}, nil
+	})
+	RegisterSource(SourceFacebook, func(conf SourceConfig) (Source, error) {
+		if conf.ID == "" {
+			return nil, sourceIDErr
+		}
+		return &FacebookSource{Tag: conf.Name, ID: conf.ID, Type: conf.Options["type"]}, nil
+	})
+}
+
+// runSource runs a fetch that knows nothing of contexts, giving up when
+// the context is done
+func runSource(ctx context.Context, fetch func() ([]RssItem, error)) ([]RssItem, error) {
+	type outcome struct {
+		items []RssItem
+		err   error
+	}
+	done := make(chan outcome, 1)
+	go func() {
+		items, err := fetch()
+		done <- outcome{items, err}
+	}()
+
+	select {
+	case o := <-done:
+		return o.items, o.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// includeSource keeps the items of a source matching a pattern
+type includeSource struct {
+	source Source
+	rp     *regexp.Regexp
+}
+
+func (s includeSource) Fetch(ctx context.Context) (SourceResult, error) {
+	result, fetchErr := s.source.Fetch(ctx)
+	found := []RssItem{}
+	for _, item := range result.Items {
+		if s.rp.MatchString(CJKnorm(item.Title + item.Description)) {
+			found = append(found, item)
+		}
+	}
+	result.Items = found
+	return result, fetchErr
+}
+
+// RSSSource reads an RSS or Atom feed
+type RSSSource struct {
+	Tag string
+	URL string
+}
+
+// Fetch implements Source
+func (s *RSSSource) Fetch(ctx context.Context) (SourceResult, error) {
+	items, fetchErr := runSource(ctx, func() ([]RssItem, error) {
+		return loadRSS(s.Tag, s.URL)
+	})
+	return SourceResult{
+		Items: items,
+		Meta:  SourceMeta{Type: SourceRSS, Name: s.Tag, URL: s.URL},
+	}, fetchErr
+}
+
+// CAPSource reads CAP alerts linked from an Atom feed, keeping the ones
+// covering the jurisdiction
+type CAPSource struct {
+	Tag          string
+	URL          string
+	Jurisdiction Jurisdiction
+}
+
+// Fetch implements Source
+func (s *CAPSource) Fetch(ctx context.Context) (SourceResult, error) {
+	items, fetchErr := runSource(ctx, func() ([]RssItem, error) {
+		return LoadCAP(s.Tag, s.URL, s.Jurisdiction)
+	})
+	return SourceResult{
+		Items: items,
+		Meta:  SourceMeta{Type: SourceCAP, Name: s.Tag, URL: s.URL},
+	}, fetchErr
+}
+
+// FacebookSource reads a page or group through the Graph adapter
+type FacebookSource struct {
+	Tag  string
+	ID   string
+	Type string
+}
+
+// facebookRssItem normalizes a post, titled by the first line of its
+// text
+func facebookRssItem(tag string, post FacebookItem) RssItem {
+	title := strings.TrimSpace(facebookText(post))
+	if i := strings.Index(title, "\n"); i >= 0 {
+		title = strings.TrimSpace(title[:i])
+	}
+	if title == "" {
+		title = post.Story
+	}
+	if utf8.RuneCountInString(title) > sourceTitleMaxLength {
+		title = string([]rune(title)[:sourceTitleMaxLength]) + "…"
+	}
+
+	h := fnv.New32a()
+	h.Write([]byte(title))
+
+	if tag == "" {
+		tag = post.Source
+	}
+
+	return RssItem{
+		Link:        post.Link,
+		OriginLink:  post.OriginLink,
+		Time:        post.Time,
+		TimeText:    post.TimeText,
+		Title:       title,
+		Source:      post.Source,
+		Tag:         tag,
+		Hash:        h.Sum32(),
+		Keyword:     "facebook.com",
+		Description: post.Message,
+	}
+}
+
+// Fetch implements Source
+func (s *FacebookSource) Fetch(ctx context.Context) (SourceResult, error) {
+	items, fetchErr := runSource(ctx, func() ([]RssItem, error) {
+		posts, feedErr := facebookGraph.Feed(s.ID, s.Type)
+		if feedErr != nil {
+			return nil, feedErr
+		}
+		collect := []RssItem{}
+		for _, post := range posts {
+			collect = append(collect, facebookRssItem(s.Tag, post))
+		}
+		return collect, nil
+	})
+	return SourceResult{
+		Items: items,
+		Meta:  SourceMeta{Type: SourceFacebook, Name: s.Tag, URL: s.ID},
+	}, fetchErr
+}
+
+// SourceStats keeps the metadata of the last fetch of every configured
+// source
+type SourceStats struct {
+	sync.RWMutex
+	metas map[string]SourceMeta
+}
+
+var sourceStats = &SourceStats{metas: make(map[string]SourceMeta)}
+
+// Record keeps the metadata of a fetch
+func (s *SourceStats) Record(meta SourceMeta) {
+	s.Lock()
+	s.metas[meta.Type+"|"+meta.Name+"|"+meta.URL] = meta
+	s.Unlock()
+}
+
+// List returns the last fetch of every source, by name
+func (s *SourceStats) List() []SourceMeta {
+	s.RLock()
+	defer s.RUnlock()
+
+	list := make([]SourceMeta, 0, len(s.metas))
+	for _, meta := range s.metas {
+		list = append(list, meta)
+	}
+	sort.Sort(bySourceName(list))
+	return list
+}
+
+type bySourceName []SourceMeta
+
+func (a bySourceName) Len() int           { return len(a) }
+func (a bySourceName) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a bySourceName) Less(i, j int) bool { return a[i].Name < a[j].Name }
+
+// FetchSources fetches sources at once and returns their items and the
+// metadata of every fetch
+func FetchSources(ctx context.Context, sources []Source) ([]RssItem, []SourceMeta) {
+	ctx, cancel := context.WithTimeout(ctx, sourceTimeout)
+	defer cancel()
+
+	results := make([]SourceResult, len(sources))
+	var wg sync.WaitGroup
+	wg.Add(len(sources))
+	for i, source := range sources {
+		go func(i int, source Source) {
+			defer wg.Done()
+
+			start := time.Now()
+			result, fetchErr := source.Fetch(ctx)
+			result.Meta.Fetched = start
+			result.Meta.Duration = int64(time.Since(start) / time.Millisecond)
+			result.Meta.Count = len(result.Items)
+			if fetchErr != nil {
+				log.Printf("FetchSources source.Fetch error: %v %v", result.Meta.Name, fetchErr)
+				result.Meta.Error = fetchErr.Error()
+			}
+			results[i] = result
+		}(i, source)
+	}
+	wg.Wait()
+
+	news := []RssItem{}
+	metas := []SourceMeta{}
+	for _, result := range results {
+		news = append(news, result.Items...)
+		metas = append(metas, result.Meta)
+	}
+	return news, metas
+}
+
+// fetchTopicSources fetches the sources of configured topics, keeping
+// their metadata for the health endpoint and watching their feeds for
+// WebSub pushes to the topic. Sources built from requests call
+// FetchSources, which keeps nothing.
+func fetchTopicSources(topic string, sources []Source) []RssItem {
+	if topic != "" {
+		for _, source := range sources {
+			watchSource(topic, source)
+		}
+	}
+
+	news, metas := FetchSources(context.Background(), sources)
+	for _, meta := range metas {
+		sourceStats.Record(meta)
+	}
+	return news
+}
+
+// buildSources builds configured sources, logging and skipping the ones
+// that cannot be built
+func buildSources(confs []SourceConfig) []Source {
+	sources := []Source{}
+	for _, conf := range confs {
+		source, sourceErr := NewSource(conf)
+		if sourceErr != nil {
+			log.Printf("buildSources NewSource error: %v %v", conf.Name, sourceErr)
+			continue
+		}
+		sources = append(sources, source)
+	}
+	return sources
+}
+
+// AddSourceTopics adds the configured sources to the topic of the same
+// name, registering topics that do not exist yet. Appended sources are
+// marked active the way the topic marks its own items.
+func AddSourceTopics(topics *TopicRegistry, configured map[string][]SourceConfig) {
+	names := []string{}
+	for name := range configured {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		name := name
+		sources := buildSources(configured[name])
+
+		topic := topics.Get(name)
+		if topic == nil {
+			topics.Add(&Topic{
+				Name: name,
+				Fetch: func() TopicResult {
+					return TopicResult{News: sourceFetcher(name, sources, false)}
+				},
+			})
+			continue
+		}
+
+		fetch, activeAll := topic.Fetch, topic.activeAll
+		topic.Fetch = func() TopicResult {
+			result := fetch()
+			// an idle topic stays empty, appended sources included
+			if state, _ := result.Extra["state"].(string); state == TopicIdle {
+				return result
+			}
+			news := cleanNews(fetchTopicSources(name, sources), activeAll)
+			result.News = UinqueElements(append(result.News, news...))
+			sort.Sort(ByTime(result.News))
+			return result
+		}
+	}
+}
+
+// sourceFetcher fetches sources and cleans their items up the way news
+// feeds are
+func sourceFetcher(topic string, sources []Source, activeAll bool) []RssItem {
+	return cleanNews(fetchTopicSources(topic, sources), activeAll)
+}
+
+// cleanNews drops duplicate, unwanted and stale items, newest first
+func cleanNews(news []RssItem, activeAll bool) []RssItem {
+	news = UinqueElements(news)
+	news = CleanupElements(news)
+	if activeAll {
+		news = ActiveAllElements(news)
+	} else {
+		news = ActiveElements(news)
+	}
+
+	sort.Sort(ByTime(news))
+
+	return news
+}
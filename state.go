@@ -0,0 +1,214 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Item states
+const (
+	StateNew          = "new"
+	StateSeen         = "seen"
+	StateAcknowledged = "acknowledged"
+	StateDismissed    = "dismissed"
+	StateEscalated    = "escalated"
+)
+
+const stateFile = "states.json"
+
+var stateUserErr = errors.New("user is required, the API key has no name")
+var stateOnBehalfErr = errors.New("only admin keys may set states for another user")
+var stateValueErr = errors.New("state must be new, seen, acknowledged, dismissed or escalated")
+
+// ItemState is one state change made by a user
+type ItemState struct {
+	State string    `json:"state"`
+	User  string    `json:"user"`
+	Time  time.Time `json:"time"`
+	Note  string    `json:"note,omitempty"`
+}
+
+// ItemStates keeps the current state of an item per user and every change
+type ItemStates struct {
+	Users   map[string]ItemState `json:"users"`
+	History []ItemState          `json:"history"`
+}
+
+func validState(state string) bool {
+	switch state {
+	case StateNew, StateSeen, StateAcknowledged, StateDismissed, StateEscalated:
+		return true
+	}
+	return false
+}
+
+// loadStates reads the state file once, the caller holds the lock
+func (a *Archive) loadStates() error {
+	if a.states != nil {
+		return nil
+	}
+
+	states := make(map[string]*ItemStates)
+	data, readErr := ioutil.ReadFile(filepath.Join(a.dir, stateFile))
+	if readErr != nil && !os.IsNotExist(readErr) {
+		return readErr
+	}
+	if readErr == nil {
+		if jsonErr := json.Unmarshal(data, &states); jsonErr != nil {
+			return jsonErr
+		}
+	}
+
+	a.states = states
+	return nil
+}
+
+func (a *Archive) writeStates() error {
+	if mkdirErr := os.MkdirAll(a.dir, 0755); mkdirErr != nil {
+		return mkdirErr
+	}
+
+	data, jsonErr := json.Marshal(a.states)
+	if jsonErr != nil {
+		return jsonErr
+	}
+
+	path := filepath.Join(a.dir, stateFile)
+	tmp := path + ".tmp"
+	if writeErr := ioutil.WriteFile(tmp, data, 0644); writeErr != nil {
+		return writeErr
+	}
+	return os.Rename(tmp, path)
+}
+
+// SetState records a state change of an item
+func (a *Archive) SetState(id string, state ItemState) (ItemStates, error) {
+	a.Lock()
+	defer a.Unlock()
+
+	if loadErr := a.loadStates(); loadErr != nil {
+		return ItemStates{}, loadErr
+	}
+
+	record := a.states[id]
+	if record == nil {
+		record = &ItemStates{Users: make(map[string]ItemState)}
+		a.states[id] = record
+	}
+	record.Users[state.User] = state
+	record.History = append(record.History, state)
+
+	return *record, a.writeStates()
+}
+
+// currentStates lists the state of every user, newest change first
+func currentStates(record ItemStates) []ItemState {
+	collect := []ItemState{}
+	for _, state := range record.Users {
+		collect = append(collect, state)
+	}
+	sort.Sort(byStateTime(collect))
+	return collect
+}
+
+type byStateTime []ItemState
+
+func (a byStateTime) Len() int           { return len(a) }
+func (a byStateTime) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a byStateTime) Less(i, j int) bool { return a[i].Time.After(a[j].Time) }
+
+// AttachStates returns a copy of news with ids and user states filled in
+func AttachStates(news []RssItem) []RssItem {
+	collect := make([]RssItem, len(news))
+
+	archive.Lock()
+	defer archive.Unlock()
+
+	loadErr := archive.loadStates()
+	for i, item := range news {
+		item.ID = itemID(item)
+		if loadErr == nil {
+			record := archive.states[item.ID]
+			if record != nil {
+				item.States = currentStates(*record)
+			}
+		}
+		collect[i] = item
+	}
+	return collect
+}
+
+// itemHistory returns every state change of an item
+func itemHistory(id string) []ItemState {
+	archive.Lock()
+	defer archive.Unlock()
+
+	if loadErr := archive.loadStates(); loadErr != nil {
+		return []ItemState{}
+	}
+	if record := archive.states[id]; record != nil {
+		return record.History
+	}
+	return []ItemState{}
+}
+
+func itemStateHandler(topics *TopicRegistry) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var body ItemState
+		if bindErr := c.BindJSON(&body); bindErr != nil {
+			return
+		}
+
+		// states are recorded as the key name, admin keys may name another
+		// user in X-Firenews-User or the body
+		user := requestKeyName(c)
+		onBehalf := strings.TrimSpace(c.Request.Header.Get("X-Firenews-User"))
+		if onBehalf == "" {
+			onBehalf = strings.TrimSpace(body.User)
+		}
+		if onBehalf != "" && onBehalf != user {
+			if !requestHasScope(c, ScopeAdmin) {
+				c.JSON(http.StatusForbidden, gin.H{"error": stateOnBehalfErr.Error()})
+				return
+			}
+			user = onBehalf
+		}
+		body.User = user
+		if body.User == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": stateUserErr.Error()})
+			return
+		}
+		if !validState(body.State) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": stateValueErr.Error()})
+			return
+		}
+
+		id := c.Param("id")
+		if _, found := findItem(topics, id); !found {
+			c.JSON(http.StatusNotFound, gin.H{"error": itemNotFoundErr.Error()})
+			return
+		}
+
+		body.Time = time.Now()
+		record, setErr := archive.SetState(id, body)
+		if setErr != nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": setErr.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"id":      id,
+			"states":  currentStates(record),
+			"history": record.History,
+		})
+	}
+}
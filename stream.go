@@ -0,0 +1,239 @@
+package main
+
+import (
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/manucorporat/sse"
+)
+
+// Topic event types
+const (
+	EventNew    = "new"
+	EventStatus = "status"
+)
+
+const eventHistorySize = 200
+
+// StreamConfig struct
+type StreamConfig struct {
+	PollInterval int `json:"pollInterval"`
+	Heartbeat    int `json:"heartbeat"`
+}
+
+// TopicEvent struct
+type TopicEvent struct {
+	ID    uint64    `json:"id"`
+	Topic string    `json:"topic"`
+	Type  string    `json:"type"`
+	Time  time.Time `json:"time"`
+	Item  RssItem   `json:"item"`
+}
+
+// EventHub fans topic events out to stream subscribers and keeps a short
+// history per topic so that clients can resume
+type EventHub struct {
+	sync.Mutex
+	seq         uint64
+	history     map[string][]TopicEvent
+	subscribers map[string]map[chan TopicEvent]bool
+}
+
+var events = NewEventHub()
+
+// NewEventHub creates an empty hub
+func NewEventHub() *EventHub {
+	return &EventHub{
+		history:     make(map[string][]TopicEvent),
+		subscribers: make(map[string]map[chan TopicEvent]bool),
+	}
+}
+
+// nextID returns increasing ids which also keep increasing across
+// restarts, so a Last-Event-ID from before a restart stays meaningful
+func (h *EventHub) nextID() uint64 {
+	h.seq++
+	if now := uint64(time.Now().UnixNano()); now > h.seq {
+		h.seq = now
+	}
+	return h.seq
+}
+
+// DiffEvents compares two snapshots of a topic
+func DiffEvents(topic string, prev []RssItem, next []RssItem) []TopicEvent {
+	status := make(map[string]int, len(prev))
+	for _, item := range prev {
+		status[itemID(item)] = item.Status
+	}
+
+	collect := []TopicEvent{}
+	for _, item := range next {
+		old, found := status[itemID(item)]
+		switch {
+		case !found:
+			collect = append(collect, TopicEvent{Topic: topic, Type: EventNew, Item: item})
+		case old != item.Status:
+			collect = append(collect, TopicEvent{Topic: topic, Type: EventStatus, Item: item})
+		}
+	}
+	return collect
+}
+
+// Publish numbers events, records them and hands them to subscribers
+func (h *EventHub) Publish(topic string, topicEvents []TopicEvent) []TopicEvent {
+	h.Lock()
+	defer h.Unlock()
+
+	now := time.Now()
+	for i := range topicEvents {
+		topicEvents[i].ID = h.nextID()
+		topicEvents[i].Time = now
+
+		history := append(h.history[topic], topicEvents[i])
+		if len(history) > eventHistorySize {
+			history = history[len(history)-eventHistorySize:]
+		}
+		h.history[topic] = history
+
+		for ch := range h.subscribers[topic] {
+			select {
+			case ch <- topicEvents[i]:
+			default:
+			}
+		}
+	}
+
+	return topicEvents
+}
+
+// Subscribe registers a subscriber and returns the events it missed
+// since lastID
+func (h *EventHub) Subscribe(topic string, lastID uint64) (chan TopicEvent, []TopicEvent) {
+	ch := make(chan TopicEvent, eventHistorySize)
+
+	h.Lock()
+	defer h.Unlock()
+
+	if h.subscribers[topic] == nil {
+		h.subscribers[topic] = make(map[chan TopicEvent]bool)
+	}
+	h.subscribers[topic][ch] = true
+
+	missed := []TopicEvent{}
+	if lastID > 0 {
+		for _, event := range h.history[topic] {
+			if event.ID > lastID {
+				missed = append(missed, event)
+			}
+		}
+	}
+
+	return ch, missed
+}
+
+// Unsubscribe removes a subscriber
+func (h *EventHub) Unsubscribe(topic string, ch chan TopicEvent) {
+	h.Lock()
+	delete(h.subscribers[topic], ch)
+	h.Unlock()
+}
+
+// pollTopic refreshes a topic forever
+func pollTopic(topic *Topic, interval time.Duration) {
+	for {
+		result := topic.Refresh()
+		time.Sleep(topicInterval(result, interval))
+	}
+}
+
+// topicInterval returns how long a result stays fresh, topics may ask
+// for their own interval through the "interval" extra field
+func topicInterval(result TopicResult, interval time.Duration) time.Duration {
+	if seconds, ok := result.Extra["interval"].(int); ok && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	return interval
+}
+
+// StartPolling keeps the snapshots of every topic fresh in the background
+func StartPolling(topics *TopicRegistry, conf StreamConfig) {
+	if conf.PollInterval <= 0 {
+		return
+	}
+	for _, topic := range topics.List() {
+		go pollTopic(topic, time.Duration(conf.PollInterval)*time.Second)
+	}
+}
+
+func isEventStream(c *gin.Context) bool {
+	return strings.HasSuffix(c.Request.URL.Path, "/stream") ||
+		strings.Contains(c.Request.Header.Get("Accept"), sse.ContentType)
+}
+
+// skipEventStreams keeps a middleware such as gzip away from event
+// streams, which must reach the client as soon as they are written
+func skipEventStreams(middleware gin.HandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if isEventStream(c) {
+			return
+		}
+		middleware(c)
+	}
+}
+
+func writeTopicEvent(w io.Writer, event TopicEvent) {
+	sse.Encode(w, sse.Event{
+		Id:    strconv.FormatUint(event.ID, 10),
+		Event: event.Type,
+		Data:  event,
+	})
+}
+
+func streamHandler(topic *Topic, conf StreamConfig) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		lastEventID := c.Request.Header.Get("Last-Event-ID")
+		if lastEventID == "" {
+			lastEventID = c.Query("lastEventId")
+		}
+		lastID, _ := strconv.ParseUint(lastEventID, 10, 64)
+
+		ch, missed := events.Subscribe(topic.Name, lastID)
+		defer events.Unsubscribe(topic.Name, ch)
+
+		heartbeat := time.Duration(conf.Heartbeat) * time.Second
+		if heartbeat <= 0 {
+			heartbeat = 15 * time.Second
+		}
+		ticker := time.NewTicker(heartbeat)
+		defer ticker.Stop()
+
+		c.Header("Content-Type", sse.ContentType)
+		c.Header("Cache-Control", "no-cache")
+		c.Header("X-Accel-Buffering", "no")
+
+		clientGone := c.Request.Context().Done()
+		c.Stream(func(w io.Writer) bool {
+			if len(missed) > 0 {
+				for _, event := range missed {
+					writeTopicEvent(w, event)
+				}
+				missed = nil
+				return true
+			}
+
+			select {
+			case <-clientGone:
+				return false
+			case event := <-ch:
+				writeTopicEvent(w, event)
+			case <-ticker.C:
+				io.WriteString(w, ": heartbeat\n\n")
+			}
+			return true
+		})
+	}
+}
@@ -0,0 +1,342 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Timeline item kinds, in the order duplicates are resolved: the first
+// kind carrying an item keeps it
+const (
+	KindCAP      = "cap"
+	KindNews     = "news"
+	KindBlogger  = "blogger"
+	KindFacebook = "facebook"
+)
+
+const (
+	defaultTimelineLimit = 200
+	maxTimelineLimit     = 1000
+)
+
+var timelineKinds = []string{KindCAP, KindNews, KindBlogger, KindFacebook}
+
+// TimelineConfig lists the sources merged into the timeline of a topic:
+// news topics, topics of CAP alerts, and facebook and blogger watch-lists
+type TimelineConfig struct {
+	News     []string `json:"news"`
+	CAP      []string `json:"cap"`
+	Facebook string   `json:"facebook"`
+	Blogger  string   `json:"blogger"`
+}
+
+// TimelineRef points at a duplicate merged into a timeline item
+type TimelineRef struct {
+	Kind   string `json:"kind"`
+	ID     string `json:"id"`
+	Source string `json:"source"`
+	Link   string `json:"link"`
+}
+
+// TimelineItem is the common shape of every kind of item, the original
+// item is kept under the field named after its kind
+type TimelineItem struct {
+	Kind     string        `json:"kind"`
+	ID       string        `json:"id"`
+	Title    string        `json:"title"`
+	Text     string        `json:"text,omitempty"`
+	Link     string        `json:"link"`
+	Source   string        `json:"source"`
+	Tag      string        `json:"tag,omitempty"`
+	Area     string        `json:"area,omitempty"`
+	Picture  string        `json:"picture,omitempty"`
+	Time     time.Time     `json:"time"`
+	TimeText string        `json:"timeText"`
+	Also     []TimelineRef `json:"also,omitempty"`
+	News     *RssItem      `json:"news,omitempty"`
+	Facebook *FacebookItem `json:"facebook,omitempty"`
+
+	keys []string
+}
+
+// TimelineError reports a source that could not be read
+type TimelineError struct {
+	Kind   string `json:"kind"`
+	Source string `json:"source"`
+	Status int    `json:"status"`
+	Error  string `json:"error"`
+}
+
+// ByTimelineTime implements sort.Interface for []TimelineItem based on
+// the Time field.
+type ByTimelineTime []TimelineItem
+
+func (a ByTimelineTime) Len() int           { return len(a) }
+func (a ByTimelineTime) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a ByTimelineTime) Less(i, j int) bool { return a[i].Time.After(a[j].Time) }
+
+// byTimelineKind orders items by the rank of their kind
+type byTimelineKind []TimelineItem
+
+func kindRank(kind string) int {
+	for i, k := range timelineKinds {
+		if k == kind {
+			return i
+		}
+	}
+	return len(timelineKinds)
+}
+
+func (a byTimelineKind) Len() int           { return len(a) }
+func (a byTimelineKind) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a byTimelineKind) Less(i, j int) bool { return kindRank(a[i].Kind) < kindRank(a[j].Kind) }
+
+// timelineConfig returns the configured timeline of a topic, or one made
+// of the news topic and the watch-lists of the same name
+func timelineConfig(topics *TopicRegistry, name string) (TimelineConfig, bool) {
+	if conf, found := config.Timelines[name]; found {
+		return conf, true
+	}
+
+	conf := TimelineConfig{}
+	if topics.Get(name) != nil {
+		conf.News = []string{name}
+	}
+	if _, found := config.Facebook.Watchlists[name]; found {
+		conf.Facebook = name
+	}
+	if _, found := config.Blogger.Watchlists[name]; found {
+		conf.Blogger = name
+	}
+	return conf, len(conf.News) > 0 || conf.Facebook != "" || conf.Blogger != ""
+}
+
+// newsTimelineItem wraps a news, blogger or CAP item
+func newsTimelineItem(kind string, item RssItem) TimelineItem {
+	item.ID = itemID(item)
+	keys := []string{item.ID}
+	if title := normalizedTitle(item.Title); title != "" {
+		keys = append(keys, "title:"+title)
+	}
+
+	return TimelineItem{
+		Kind:     kind,
+		ID:       item.ID,
+		Title:    item.Title,
+		Text:     item.Description,
+		Link:     canonicalLink(item),
+		Source:   item.Source,
+		Tag:      item.Tag,
+		Area:     item.Area,
+		Time:     item.Time,
+		TimeText: item.TimeText,
+		News:     &item,
+		keys:     keys,
+	}
+}
+
+// facebookTimelineItem wraps a post, which is the same item as the news
+// it shares
+func facebookTimelineItem(item FacebookItem) TimelineItem {
+	keys := []string{"facebook:" + item.ID}
+	title := item.Message
+	if item.SharedLink != nil {
+		if item.SharedLink.NewsID != "" {
+			keys = append(keys, item.SharedLink.NewsID)
+		}
+		if shared := normalizedTitle(item.SharedLink.Title); shared != "" {
+			keys = append(keys, "title:"+shared)
+		}
+		if title == "" {
+			title = item.SharedLink.Title
+		}
+	}
+	if title == "" {
+		title = item.Story
+	}
+
+	return TimelineItem{
+		Kind:     KindFacebook,
+		ID:       item.ID,
+		Title:    title,
+		Text:     item.Message,
+		Link:     item.Link,
+		Source:   item.Source,
+		Picture:  item.Picture,
+		Time:     item.Time,
+		TimeText: item.TimeText,
+		Facebook: &item,
+		keys:     keys,
+	}
+}
+
+// MergeTimeline keeps one item per story across kinds, the earlier kind
+// in timelineKinds winning and listing the others, newest first
+func MergeTimeline(items []TimelineItem) []TimelineItem {
+	sort.Stable(byTimelineKind(items))
+
+	merged := []TimelineItem{}
+	index := make(map[string]int)
+	for _, item := range items {
+		found := -1
+		for _, key := range item.keys {
+			if i, ok := index[key]; ok {
+				found = i
+				break
+			}
+		}
+		if found < 0 {
+			merged = append(merged, item)
+			found = len(merged) - 1
+		} else if merged[found].Kind != item.Kind || merged[found].ID != item.ID {
+			merged[found].Also = append(merged[found].Also, TimelineRef{
+				Kind:   item.Kind,
+				ID:     item.ID,
+				Source: item.Source,
+				Link:   item.Link,
+			})
+		}
+		for _, key := range item.keys {
+			index[key] = found
+		}
+	}
+
+	sort.Sort(ByTimelineTime(merged))
+	return merged
+}
+
+// BuildTimeline reads every source of a timeline at once
+func BuildTimeline(topics *TopicRegistry, conf TimelineConfig, kinds []string) ([]TimelineItem, []TimelineError) {
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+	items := []TimelineItem{}
+	errs := []TimelineError{}
+
+	add := func(found []TimelineItem, failures []TimelineError) {
+		mutex.Lock()
+		items = append(items, found...)
+		errs = append(errs, failures...)
+		mutex.Unlock()
+	}
+
+	wanted := func(kind string) bool {
+		return len(kinds) == 0 || containsString(kinds, kind)
+	}
+
+	fromTopics := func(kind string, names []string) {
+		for _, name := range names {
+			topic := topics.Get(name)
+			if topic == nil {
+				add(nil, []TimelineError{{Kind: kind, Source: name, Status: http.StatusNotFound, Error: "no topic " + name}})
+				continue
+			}
+			found := []TimelineItem{}
+			for _, item := range topic.Current().Result.News {
+				found = append(found, newsTimelineItem(kind, item))
+			}
+			add(found, nil)
+		}
+	}
+
+	for _, kind := range []string{KindNews, KindCAP} {
+		names := conf.News
+		if kind == KindCAP {
+			names = conf.CAP
+		}
+		if wanted(kind) && len(names) > 0 {
+			wg.Add(1)
+			go func(kind string, names []string) {
+				defer wg.Done()
+				fromTopics(kind, names)
+			}(kind, names)
+		}
+	}
+
+	if wanted(KindBlogger) && conf.Blogger != "" {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			found := []TimelineItem{}
+			for _, item := range BloggerTimeline(conf.Blogger) {
+				found = append(found, newsTimelineItem(KindBlogger, item))
+			}
+			add(found, nil)
+		}()
+	}
+
+	if wanted(KindFacebook) && conf.Facebook != "" {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			posts, watchErrs := facebookGraph.Timeline(conf.Facebook)
+			found := []TimelineItem{}
+			for _, post := range posts {
+				found = append(found, facebookTimelineItem(post))
+			}
+			failures := []TimelineError{}
+			for _, watchErr := range watchErrs {
+				failures = append(failures, TimelineError{Kind: KindFacebook, Source: watchErr.ID, Status: watchErr.Status, Error: watchErr.Error})
+			}
+			add(found, failures)
+		}()
+	}
+
+	wg.Wait()
+	return MergeTimeline(items), errs
+}
+
+func timelineHandler(topics *TopicRegistry) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		name := c.Param("topic")
+		conf, found := timelineConfig(topics, name)
+		if !found {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": "no timeline for " + name,
+			})
+			return
+		}
+
+		kinds := []string{}
+		if text := c.Query("kind"); text != "" {
+			for _, kind := range strings.Split(text, ",") {
+				kind = strings.TrimSpace(kind)
+				if !containsString(timelineKinds, kind) {
+					c.JSON(http.StatusBadRequest, gin.H{
+						"error": "kind must be one of " + strings.Join(timelineKinds, ", "),
+					})
+					return
+				}
+				kinds = append(kinds, kind)
+			}
+		}
+
+		limit := defaultTimelineLimit
+		if text := c.Query("limit"); text != "" {
+			n, parseErr := strconv.Atoi(text)
+			if parseErr != nil || n < 1 || n > maxTimelineLimit {
+				c.JSON(http.StatusBadRequest, gin.H{
+					"error": "limit must be between 1 and " + strconv.Itoa(maxTimelineLimit),
+				})
+				return
+			}
+			limit = n
+		}
+
+		items, errs := BuildTimeline(topics, conf, kinds)
+		if len(items) > limit {
+			items = items[:limit]
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"topic":  name,
+			"items":  items,
+			"errors": errs,
+		})
+	}
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Topic struct
+type Topic struct {
+	sync.Mutex
+	Name  string
+	Fetch func() TopicResult
+
+	// activeAll marks every item of the topic active, as its fetch does
+	activeAll bool
+}
+
+// TopicResult struct
+type TopicResult struct {
+	News  []RssItem
+	Extra gin.H
+}
+
+// Snapshot is the latest result of a topic
+type Snapshot struct {
+	Topic  string
+	Time   time.Time
+	Result TopicResult
+}
+
+// TopicRegistry keeps topics in registration order
+type TopicRegistry struct {
+	names  []string
+	topics map[string]*Topic
+}
+
+// SnapshotStore keeps the latest snapshot of every topic
+type SnapshotStore struct {
+	sync.RWMutex
+	snapshots map[string]Snapshot
+}
+
+var snapshots = NewSnapshotStore()
+
+// NewTopicRegistry creates an empty registry
+func NewTopicRegistry() *TopicRegistry {
+	return &TopicRegistry{
+		topics: make(map[string]*Topic),
+	}
+}
+
+// Add registers a topic
+func (r *TopicRegistry) Add(topic *Topic) {
+	if _, found := r.topics[topic.Name]; !found {
+		r.names = append(r.names, topic.Name)
+	}
+	r.topics[topic.Name] = topic
+}
+
+// Get returns a topic by name, or nil
+func (r *TopicRegistry) Get(name string) *Topic {
+	return r.topics[name]
+}
+
+// List returns the topics in registration order
+func (r *TopicRegistry) List() []*Topic {
+	list := make([]*Topic, 0, len(r.names))
+	for _, name := range r.names {
+		list = append(list, r.topics[name])
+	}
+	return list
+}
+
+// NewSnapshotStore creates an empty store
+func NewSnapshotStore() *SnapshotStore {
+	return &SnapshotStore{
+		snapshots: make(map[string]Snapshot),
+	}
+}
+
+// Get returns the latest snapshot of a topic
+func (s *SnapshotStore) Get(topic string) (Snapshot, bool) {
+	s.RLock()
+	defer s.RUnlock()
+	snapshot, found := s.snapshots[topic]
+	return snapshot, found
+}
+
+// Set replaces the snapshot of a topic
+func (s *SnapshotStore) Set(topic string, result TopicResult) Snapshot {
+	snapshot := Snapshot{
+		Topic:  topic,
+		Time:   time.Now(),
+		Result: result,
+	}
+
+	s.Lock()
+	s.snapshots[topic] = snapshot
+	s.Unlock()
+
+	return snapshot
+}
+
+// Refresh fetches a topic, keeps the result as its snapshot, publishes
+// what changed since the last one, archives the items, sends webhooks and
+// posts new items to WebSub subscribers
+func (t *Topic) Refresh() TopicResult {
+	result := t.Fetch()
+
+	t.Lock()
+	t.swap(result)
+	t.Unlock()
+
+	t.publish(result.News)
+	return result
+}
+
+// Merge adds items pushed by a WebSub hub to the snapshot of a topic and
+// handles them as Refresh would. Topics not fetched yet, and idle ones,
+// are left alone.
+func (t *Topic) Merge(news []RssItem) {
+	t.Lock()
+	prev, found := snapshots.Get(t.Name)
+	if state, _ := prev.Result.Extra["state"].(string); !found || state == TopicIdle {
+		t.Unlock()
+		return
+	}
+
+	merged := append([]RssItem{}, prev.Result.News...)
+	merged = UinqueElements(append(merged, cleanNews(news, t.activeAll)...))
+	sort.Sort(ByTime(merged))
+
+	result := TopicResult{News: merged, Extra: prev.Result.Extra}
+	t.swap(result)
+	t.Unlock()
+
+	t.publish(result.News)
+}
+
+// swap replaces the snapshot and publishes the difference, the caller
+// holds the lock
+func (t *Topic) swap(result TopicResult) {
+	for i := range result.News {
+		result.News[i].ID = itemID(result.News[i])
+	}
+
+	prev, found := snapshots.Get(t.Name)
+	snapshots.Set(t.Name, result)
+	if found {
+		events.Publish(t.Name, DiffEvents(t.Name, prev.Result.News, result.News))
+	}
+}
+
+// publish archives the items of a snapshot and sends them on
+func (t *Topic) publish(news []RssItem) {
+	if archiveErr := archive.Save(t.Name, news); archiveErr != nil {
+		log.Printf("Refresh archive.Save error: %v", archiveErr)
+	}
+	notifier.Notify(t.Name, news)
+	websubHub.Publish(t.Name, news)
+}
+
+// Current returns the snapshot of a topic, refreshing it when missing or
+// older than the poll interval. With polling off every call refreshes,
+// the feeds stay behind the cache.
+func (t *Topic) Current() Snapshot {
+	interval := time.Duration(config.Stream.PollInterval) * time.Second
+	snapshot, found := snapshots.Get(t.Name)
+	if found && interval > 0 && time.Since(snapshot.Time) < topicInterval(snapshot.Result, interval) {
+		return snapshot
+	}
+	t.Refresh()
+	snapshot, _ = snapshots.Get(t.Name)
+	return snapshot
+}
+
+func topicHandler(topic *Topic) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		query, queryErr := ParseNewsQuery(c)
+		if queryErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": queryErr.Error(),
+			})
+			return
+		}
+
+		// requests are served from the snapshot, the poller and WebSub
+		// pushes keep it fresh
+		result := topic.Current().Result
+		news, next := query.Apply(result.News)
+
+		extra := gin.H{}
+		for k, v := range result.Extra {
+			extra[k] = v
+		}
+		extra["nextCursor"] = next
+		if next != "" {
+			c.Header("X-Next-Cursor", next)
+		}
+
+		renderNews(c, topic.Name, AttachStates(news), extra)
+	}
+}
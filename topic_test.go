@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func getTopicPage(t *testing.T, router *gin.Engine, path string) ([]RssItem, string) {
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET %s = %d %s", path, w.Code, w.Body.String())
+	}
+
+	var body struct {
+		News       []RssItem `json:"news"`
+		NextCursor string    `json:"nextCursor"`
+	}
+	if jsonErr := json.Unmarshal(w.Body.Bytes(), &body); jsonErr != nil {
+		t.Fatal(jsonErr)
+	}
+	return body.News, body.NextCursor
+}
+
+func TestTopicHandlerPagesSnapshot(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	base := time.Date(2018, 2, 6, 12, 0, 0, 0, time.UTC)
+	news := []RssItem{}
+	for i := 0; i < 5; i++ {
+		news = append(news, RssItem{
+			Title: "item " + strconv.Itoa(i),
+			Link:  "http://example.com/" + strconv.Itoa(i),
+			Time:  base.Add(-time.Duration(i) * time.Minute),
+		})
+	}
+
+	topic := &Topic{
+		Name: "paging-test",
+		Fetch: func() TopicResult {
+			t.Fatal("requests must not fetch the topic")
+			return TopicResult{}
+		},
+	}
+	snapshots.Set(topic.Name, TopicResult{News: news})
+
+	router := gin.New()
+	router.GET("/news", topicHandler(topic))
+
+	first, next := getTopicPage(t, router, "/news?limit=2&format=json")
+	if len(first) != 2 || first[0].Title != "item 0" || first[1].Title != "item 1" || next == "" {
+		t.Fatalf("first page = %v, next %q", first, next)
+	}
+
+	// a newer item arriving between requests does not shift later pages
+	fresh := append([]RssItem{{
+		Title: "item new",
+		Link:  "http://example.com/new",
+		Time:  base.Add(time.Minute),
+	}}, news...)
+	snapshots.Set(topic.Name, TopicResult{News: fresh})
+
+	second, next := getTopicPage(t, router, "/news?limit=2&format=json&cursor="+next)
+	if len(second) != 2 || second[0].Title != "item 2" || second[1].Title != "item 3" || next == "" {
+		t.Fatalf("second page = %v, next %q", second, next)
+	}
+
+	last, next := getTopicPage(t, router, "/news?limit=2&format=json&cursor="+next)
+	if len(last) != 1 || last[0].Title != "item 4" || next != "" {
+		t.Fatalf("last page = %v, next %q", last, next)
+	}
+}
+
+func TestTopicMergeActiveAll(t *testing.T) {
+	dir, tempErr := ioutil.TempDir("", "topic")
+	if tempErr != nil {
+		t.Fatal(tempErr)
+	}
+	defer os.RemoveAll(dir)
+
+	savedArchive := archive
+	defer func() { archive = savedArchive }()
+	archive = NewArchive(dir)
+
+	topic := &Topic{Name: "merge-test", activeAll: true}
+	snapshots.Set(topic.Name, TopicResult{News: []RssItem{{
+		Title:  "竹市水情吃緊",
+		Link:   "http://example.com/old",
+		Time:   time.Now().Add(-time.Hour),
+		Status: 1,
+	}}})
+
+	// pushed items are marked as the topic's own fetch marks them
+	topic.Merge([]RssItem{{
+		Title: "竹市限水 下週實施",
+		Link:  "http://example.com/new",
+		Time:  time.Now(),
+	}})
+
+	snapshot, _ := snapshots.Get(topic.Name)
+	if len(snapshot.Result.News) != 2 {
+		t.Fatalf("snapshot = %+v", snapshot.Result.News)
+	}
+	for _, item := range snapshot.Result.News {
+		if item.Status != 1 {
+			t.Errorf("%q has status %d, want 1", item.Title, item.Status)
+		}
+	}
+}
+
+func TestTopicCurrentRefreshesStaleSnapshot(t *testing.T) {
+	dir, tempErr := ioutil.TempDir("", "topic")
+	if tempErr != nil {
+		t.Fatal(tempErr)
+	}
+	defer os.RemoveAll(dir)
+
+	savedArchive, savedStream := archive, config.Stream
+	defer func() { archive, config.Stream = savedArchive, savedStream }()
+	archive = NewArchive(dir)
+
+	fetches := 0
+	topic := &Topic{
+		Name: "current-test",
+		Fetch: func() TopicResult {
+			fetches++
+			return TopicResult{News: []RssItem{}}
+		},
+	}
+
+	config.Stream.PollInterval = 300
+	topic.Current()
+	topic.Current()
+	if fetches != 1 {
+		t.Errorf("a fresh snapshot was fetched %d times, want 1", fetches)
+	}
+
+	// with polling off requests keep the topic fresh
+	config.Stream.PollInterval = 0
+	topic.Current()
+	topic.Current()
+	if fetches != 3 {
+		t.Errorf("fetched %d times with polling off, want 3", fetches)
+	}
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"log"
+	"sort"
+
+	"github.com/gin-gonic/gin"
+)
+
+// newTopics registers the news topics served under /api/news/v1
+func newTopics(filterAPIPoint string) *TopicRegistry {
+	topics := NewTopicRegistry()
+
+	topics.Add(&Topic{
+		Name: "main",
+		Fetch: func() TopicResult {
+			includeText := "%E6%B6%88%E9%98%B2%7C%E7%81%AB%E8%AD%A6%7C%E7%81%AB%E7%81%BD%7C%E7%81%AB%E8%AD%A6%7C%E7%81%AB%E7%87%92%7C%E5%A4%A7%E7%81%AB%7C%E6%95%91%E8%AD%B7%7C%E6%95%91%E7%81%BD%7C%E9%80%81%E9%86%AB%7C%E8%AD%A6%E6%B6%88%7C%E7%BE%A9%E6%B6%88%7C%E8%90%BD%E8%BB%8C%7C%E8%B7%B3%E8%BB%8C%7C%E4%BD%8F%E8%AD%A6%E5%99%A8%7C%E4%BD%8F%E5%AE%85%E8%AD%A6%E5%A0%B1%E5%99%A8%7C%E4%BD%8F%E5%AE%85%E7%81%AB%E8%AD%A6%E5%99%A8%7C%E5%8F%B0%E9%90%B5%E9%A6%99%E5%B1%B1%7C%E9%A6%99%E5%B1%B1%E7%81%AB%E8%BB%8A%E7%AB%99%7C%E9%A6%99%E5%B1%B1%E7%AB%99%7C%E9%9B%B2%E6%A2%AF%7C%E6%B6%88%E9%98%B2.%2A%E9%A6%99%E5%B1%B1%7C%E9%A6%99%E5%B1%B1.%2A%E6%B6%88%E9%98%B2%7CCPR"
+
+			feeds := map[string]string{
+				"消防": "https://www.google.com.tw/alerts/feeds/04784784225885481651/1432933957568832221",
+				"火燒||火警||火災||大火||住警器||住宅警報器||住宅火警器||義消||落軌||跳軌||台鐵香山||香山火車站||香山站||雲梯||打火": "https://www.google.com.tw/alerts/feeds/04784784225885481651/11834919735038606131",
+				"救護":  "https://www.google.com.tw/alerts/feeds/04784784225885481651/10937227332545439311",
+				"救災":  "https://www.google.com.tw/alerts/feeds/04784784225885481651/15512682411139935187",
+				"送醫":  "https://www.google.com.tw/alerts/feeds/04784784225885481651/7089524768908772692",
+				"cpr": "https://www.google.com.tw/alerts/feeds/04784784225885481651/1999534239766046938",
+				"蘋果日報社會版":     filterAPIPoint + "filter?url=http%3A%2F%2Fwww.appledaily.com.tw%2Frss%2Fcreate%2Fkind%2Frnews%2Ftype%2F102&include=" + includeText,
+				"自由時報社會版":     filterAPIPoint + "filter?url=http%3A%2F%2Fnews.ltn.com.tw%2Frss%2Fsociety.xml&include=" + includeText,
+				"聯合新聞社會版":     filterAPIPoint + "filter?url=http%3A%2F%2Fudn.com%2Fudnrss%2Fsocial.xml&include=" + includeText,
+				"中國時報社會版":     filterAPIPoint + "filter?url=http%3A%2F%2Fwww.chinatimes.com%2Frss%2Frealtimenews-society.xml&include=" + includeText,
+				"蘋果日報國際版":     filterAPIPoint + "filter?url=http%3A%2F%2Fwww.appledaily.com.tw%2Frss%2Fcreate%2Fkind%2Frnews%2Ftype%2F103&include=" + includeText,
+				"自由時報國際版":     filterAPIPoint + "filter?url=http%3A%2F%2Fnews.ltn.com.tw%2Frss%2Fworld.xml&include=" + includeText,
+				"聯合新聞國際版":     filterAPIPoint + "filter?url=http%3A%2F%2Fudn.com%2Fudnrss%2FBREAKINGNEWS4.xml&include=" + includeText,
+				"中國時報國際版":     filterAPIPoint + "filter?url=http%3A%2F%2Fwww.chinatimes.com%2Frss%2Frealtimenews-international.xml&include=" + includeText,
+				"民眾日報（記者方詠騰）": filterAPIPoint + "filter?url=http%3A%2F%2Fwww.mypeople.tw%2Frss%2F&include=" + includeText,
+				"台灣新生報 地方綜合":  filterAPIPoint + "filter?url=http%3A%2F%2Ffeeds.feedburner.com%2Ftssdnews&include=" + includeText,
+				"蘋果日報即時":      filterAPIPoint + "filter?url=http%3A%2F%2Fwww.appledaily.com.tw%2Frss%2Fcreate%2Fkind%2Frnews%2Ftype%2Fnew&include=" + includeText,
+			}
+
+			return TopicResult{News: newsFetcher("main", feeds, false)}
+		},
+	})
+	topics.Add(&Topic{
+		Name: "city",
+		Fetch: func() TopicResult {
+			includeText := "%E7%AB%B9%E5%B8%82"
+			feeds := map[string]string{
+				"Google 快訊 竹市||台鐵香山||香山火車站||香山站": "https://www.google.com.tw/alerts/feeds/04784784225885481651/2705564241123909653",
+				"中國時報地方版":                        filterAPIPoint + "filter?url=http%3A%2F%2Fwww.chinatimes.com%2Frss%2Fchinatimes-local.xml&include=" + includeText,
+				"聯合新聞地方桃竹苗版":                     filterAPIPoint + "filter?url=http%3A%2F%2Fudn.com%2Frssfeed%2Fnews%2F2%2F6641%2F7324%3Fch%3Dnews&include=" + includeText,
+				"自由時報地方版":                        filterAPIPoint + "filter?url=http%3A%2F%2Fnews.ltn.com.tw%2Frss%2Flocal.xml&include=" + includeText,
+				"蘋果日報地方綜合":                       filterAPIPoint + "filter?url=http%3A%2F%2Fwww.appledaily.com.tw%2Frss%2Fcreate%2Fkind%2Fsec%2Ftype%2F1076&include=" + includeText,
+				"中國時報社會版":                        filterAPIPoint + "filter?url=http%3A%2F%2Fwww.chinatimes.com%2Frss%2Frealtimenews-society.xml&include=" + includeText,
+				"聯合新聞社會版":                        filterAPIPoint + "filter?url=http%3A%2F%2Fudn.com%2Fudnrss%2Fsocial.xml&include=" + includeText,
+				"自由時報社會版":                        filterAPIPoint + "filter?url=http%3A%2F%2Fnews.ltn.com.tw%2Frss%2Fsociety.xml&include=" + includeText,
+				"蘋果日報社會版":                        filterAPIPoint + "filter?url=http%3A%2F%2Fwww.appledaily.com.tw%2Frss%2Fcreate%2Fkind%2Frnews%2Ftype%2F102&include=" + includeText,
+				"民眾日報（記者方詠騰）":                    filterAPIPoint + "filter?url=http%3A%2F%2Fwww.mypeople.tw%2Frss%2F&include=" + includeText,
+				"台灣新生報 地方綜合":                     filterAPIPoint + "filter?url=http%3A%2F%2Ffeeds.feedburner.com%2Ftssdnews&include=" + includeText,
+				"蘋果日報 要聞":                        filterAPIPoint + "filter?url=http%3A%2F%2Fwww.appledaily.com.tw%2Frss%2Fcreate%2Fkind%2Fsec%2Ftype%2F11&include=" + includeText,
+			}
+
+			return TopicResult{News: newsFetcher("city", feeds, false)}
+		},
+	})
+	topics.Add(&Topic{
+		Name:      "drought",
+		activeAll: true,
+		Fetch: func() TopicResult {
+			includeText := "竹.*乾旱|乾旱.*竹|竹.*缺水|缺水.*竹|竹.*旱災|旱災.*竹|竹.*停水|停水.*竹|竹.*限水|限水.*竹|竹.*水情|水情.*竹|竹.*旱季|旱季.*竹|竹.*供水|供水.*竹|竹.*蓄水|蓄水.*竹"
+			feeds := map[string]string{
+				"Google 快訊 乾旱||缺水||旱災||停水||限水||水情||旱季||供水||蓄水": filterAPIPoint + "filter?url=https://www.google.com.tw/alerts/feeds/04784784225885481651/15900314494794676328&include=" + includeText,
+				"中國時報焦點": filterAPIPoint + "filter?url=http%3A%2F%2Fwww.chinatimes.com%2Frss%2Frealtimenews-focus.xml&include=" + includeText,
+				"聯合新聞最新": filterAPIPoint + "filter?url=http%3A%2F%2Fudn.com%2Fudnrss%2Flatest.xml&include=" + includeText,
+				"自由時報頭版": filterAPIPoint + "filter?url=http%3A%2F%2Fnews.ltn.com.tw%2Frss%2Ffocus.xml&include=" + includeText,
+				"蘋果日報最新": filterAPIPoint + "filter?url=http%3A%2F%2Fwww.appledaily.com.tw%2Frss%2Fcreate%2Fkind%2Frnews%2Ftype%2Fnew&include=" + includeText,
+			}
+
+			return TopicResult{News: newsFetcher("drought", feeds, true)}
+		},
+	})
+	topics.Add(&Topic{
+		Name: "typhon",
+		Fetch: func() TopicResult {
+			feeds := map[string]string{
+				"颱風":     "https://www.google.com.tw/alerts/feeds/04784784225885481651/5973699102355057312",
+				"熱帶低氣壓":  "https://www.google.com.tw/alerts/feeds/04784784225885481651/9494720717694166142",
+				"輕颱":     "https://www.google.com.tw/alerts/feeds/04784784225885481651/13369455153026830745",
+				"中颱":     "https://www.google.com.tw/alerts/feeds/04784784225885481651/13369455153026831531",
+				"強颱":     "https://www.google.com.tw/alerts/feeds/04784784225885481651/13369455153026831346",
+				"中國時報焦點": filterAPIPoint + "filter?url=http%3A%2F%2Fwww.chinatimes.com%2Frss%2Frealtimenews-focus.xml&include=%E9%A2%B1%E9%A2%A8%7C%E8%BC%95%E9%A2%B1%7C%E4%B8%AD%E9%A2%B1%7C%E5%BC%B7%E9%A2%B1%7C%E7%86%B1%E5%B8%B6%E4%BD%8E%E6%B0%A3%E5%A3%93",
+				"聯合新聞最新": filterAPIPoint + "filter?url=http%3A%2F%2Fudn.com%2Fudnrss%2Flatest.xml&include=%E9%A2%B1%E9%A2%A8%7C%E8%BC%95%E9%A2%B1%7C%E4%B8%AD%E9%A2%B1%7C%E5%BC%B7%E9%A2%B1%7C%E7%86%B1%E5%B8%B6%E4%BD%8E%E6%B0%A3%E5%A3%93",
+				"自由時報頭版": filterAPIPoint + "filter?url=http%3A%2F%2Fnews.ltn.com.tw%2Frss%2Ffocus.xml&include=%E9%A2%B1%E9%A2%A8%7C%E8%BC%95%E9%A2%B1%7C%E4%B8%AD%E9%A2%B1%7C%E5%BC%B7%E9%A2%B1%7C%E7%86%B1%E5%B8%B6%E4%BD%8E%E6%B0%A3%E5%A3%93",
+				"蘋果日報最新": filterAPIPoint + "filter?url=http%3A%2F%2Fwww.appledaily.com.tw%2Frss%2Fcreate%2Fkind%2Frnews%2Ftype%2Fnew&include=%E9%A2%B1%E9%A2%A8%7C%E8%BC%95%E9%A2%B1%7C%E4%B8%AD%E9%A2%B1%7C%E5%BC%B7%E9%A2%B1%7C%E7%86%B1%E5%B8%B6%E4%BD%8E%E6%B0%A3%E5%A3%93",
+			}
+
+			// an outage of the bulletin feed keeps the last known state
+			// and news, or reports the state as unknown before the first
+			// bulletins, rather than falling back to idle
+			bulletins, bulletinErr := LoadTyphoonBulletins(config.Typhoon.URL, config.Jurisdiction)
+			state := TyphoonState(bulletins)
+			if bulletinErr != nil {
+				log.Printf("typhon LoadTyphoonBulletins error: %v", bulletinErr)
+				if prev, found := snapshots.Get("typhon"); found {
+					return prev.Result
+				}
+				state = TopicUnknown
+			}
+			if state == TopicIdle {
+				return TopicResult{
+					News: []RssItem{},
+					Extra: gin.H{
+						"state":    state,
+						"interval": TyphoonInterval(state, config.Typhoon),
+					},
+				}
+			}
+
+			news := append(newsFetcher("typhon", feeds, false), BulletinElements(bulletins)...)
+			sort.Sort(ByTime(news))
+
+			extra := gin.H{
+				"state":    state,
+				"interval": TyphoonInterval(state, config.Typhoon),
+			}
+			if bulletinErr != nil {
+				extra["error"] = bulletinErr.Error()
+			}
+			return TopicResult{
+				News:  news,
+				Extra: extra,
+			}
+		},
+	})
+	earthquakeFeeds := map[string]string{
+		"地震":     "https://www.google.com.tw/alerts/feeds/04784784225885481651/11159700034107135548",
+		"中國時報總覽": filterAPIPoint + "filter?url=http%3A%2F%2Fwww.chinatimes.com%2Frss%2Frealtimenews.xml&include=%E5%9C%B0%E9%9C%87",
+		"聯合新聞最新": filterAPIPoint + "filter?url=http%3A%2F%2Fudn.com%2Fudnrss%2Flatest.xml&include=%E5%9C%B0%E9%9C%87",
+		"自由時報頭版": filterAPIPoint + "filter?url=http%3A%2F%2Fnews.ltn.com.tw%2Frss%2Ffocus.xml&include=%E5%9C%B0%E9%9C%87",
+		"蘋果日報最新": filterAPIPoint + "filter?url=http%3A%2F%2Fwww.appledaily.com.tw%2Frss%2Fcreate%2Fkind%2Frnews%2Ftype%2Fnew&include=%E5%9C%B0%E9%9C%87",
+	}
+	topics.Add(&Topic{
+		Name: "earthquake",
+		Fetch: func() TopicResult {
+			return TopicResult{News: newsFetcher("earthquake", earthquakeFeeds, false)}
+		},
+	})
+	topics.Add(&Topic{
+		Name: "ncdr",
+		Fetch: func() TopicResult {
+			feeds := map[string]string{
+				"地震": "https://alerts.ncdr.nat.gov.tw/RssAtomFeed.ashx?AlertType=6",
+			}
+
+			return TopicResult{News: capFetcher(feeds, config.Jurisdiction)}
+		},
+	})
+	topics.Add(&Topic{
+		Name:      "hcfd",
+		activeAll: true,
+		Fetch: func() TopicResult {
+			includeText := "竹市.*火勢|火勢.*竹市|竹市.*大火|大火.*竹市|竹市.*火災|火災.*竹市|竹市.*火警|火警.*竹市|竹市.*消防|消防.*竹市|竹市.*住警器|住警器.*竹市|竹市.*住宅火警器|住宅火警器.*竹市|竹市.*雲梯|雲梯.*竹市|林智堅.*雲梯|雲梯.*林智堅|消防.*香山|香山.*消防|消防.*林智堅|林智堅.*消防|竹市.*義消|義消.*竹市|義消.*林智堅|林智堅.*義消|竹市.*防災|防災.*竹市|新竹.*淹水|淹水.*新竹|竹市.*淹水|淹水.*竹市|竹市.*CPR|CPR.*竹市|竹市.*AED|AED.*竹市|竹市.*救護|救護.*竹市|竹市.*特搜|特搜.*竹市|竹市.*搶救|搶救.*竹市|竹市.*救援|救援.*竹市|竹市.*警消|警消.*竹市|竹市.*鳳凰志工|鳳凰志工.*竹市|消安.*竹市|竹市.*消安|防火.*竹市|竹市.*防火|竄火.*竹市|竹市.*竄火|被燒.*竹市|竹市.*被燒|中毒.*竹市|竹市.*中毒|竹市.*臥軌|臥軌.*竹市|竹市.*跳軌|跳軌.*竹市|竹市.*落軌|落軌.*竹市|新竹.*臥軌|臥軌.*新竹|新竹.*跳軌|跳軌.*新竹|新竹.*落軌|落軌.*新竹|竹市.*燒炭|燒炭.*竹市"
+			feeds := map[string]string{
+				"消防": filterAPIPoint + "filter?url=https%3A%2F%2Fwww.google.com.tw%2Falerts%2Ffeeds%2F04784784225885481651%2F1432933957568832221&include=" + includeText,
+				"聯合新聞網（記者王敏旭、林麒偉）":                                                                      filterAPIPoint + "filter?url=http%3A%2F%2Fudn.com%2Frssfeed%2Fnews%2F1%2F2%3Fch%3Dnews&include=" + includeText,
+				"自由時報（記者王駿杰、蔡彰盛、洪美秀）":                                                                   filterAPIPoint + "filter?url=http%3A%2F%2Fnews.ltn.com.tw%2Frss%2Fnorthern.xml&include=" + includeText,
+				"中時電子報（記者徐養齡、郭芝函）":                                                                      filterAPIPoint + "filter?url=http%3A%2F%2Fwww.chinatimes.com%2Frss%2Frealtimenews-society.xml&include=" + includeText,
+				"中時電子報生活版":                                                                              filterAPIPoint + "filter?url=http%3A%2F%2Fwww.chinatimes.com%2Frss%2Frealtimenews-life.xml&include=" + includeText,
+				"中時電子報地方版":                                                                              filterAPIPoint + "filter?url=http%3A%2F%2Fwww.chinatimes.com%2Frss%2Frealtimenews-local.xml&include=" + includeText,
+				"中央社（記者魯鋼駿）":                                                                            filterAPIPoint + "filter?url=http%3A%2F%2Ffeeds.feedburner.com%2Frsscna%2Flocal&include=" + includeText,
+				"勁報（勁報記者羅蔚舟）":                                                                           filterAPIPoint + "filter?url=http%3A%2F%2Fwww.twpowernews.com%2Fhome%2Frss.php&include=" + includeText,
+				"真晨報（記者王萱）":                                                                             filterAPIPoint + "filter?url=http%3A%2F%2Ffeeds.feedburner.com%2F5550555&include=" + includeText,
+				"臺灣時報（記者鄭銘德）":                                                                           filterAPIPoint + "filter?url=http%3A%2F%2Ffeeds.feedburner.com%2Ftwtimesrss&include=" + includeText,
+				"ETtoday（新竹振道記者蔡文綺、記者萬世璉）":                                                              filterAPIPoint + "filter?url=http%3A%2F%2Ffeeds.feedburner.com%2Fettoday%2Flocal&include=" + includeText,
+				"民眾日報（記者方詠騰）":                                                                           filterAPIPoint + "filter?url=http%3A%2F%2Fwww.mypeople.tw%2Frss&include=" + includeText,
+				"青年日報（記者余華昌）":                                                                           filterAPIPoint + "filter?url=http%3A%2F%2Ffeeds.feedburner.com%2Fgov%2FckHD&include=" + includeText,
+				"台灣新聞報（記者戴欣怡）":                                                                          filterAPIPoint + "filter?url=http%3A%2F%2Ffeeds.feedburner.com%2Ftwnewsdaily&include=" + includeText,
+				"Google 快訊 竹市||台鐵香山||香山火車站||香山站":                                                        filterAPIPoint + "filter?url=https%3A%2F%2Fwww.google.com.tw%2Falerts%2Ffeeds%2F04784784225885481651%2F2705564241123909653&include=" + includeText,
+				"Google 快訊 竹市消防局||勤務派遣科":                                                                filterAPIPoint + "filter?url=https%3A%2F%2Fwww.google.com.tw%2Falerts%2Ffeeds%2F04784784225885481651%2F7890686135979287740&include=" + includeText,
+				"Google 快訊 火燒||火警||火災||大火||住警器||住宅警報器||住宅火警器||義消||落軌||跳軌||臥軌||台鐵香山||香山火車站||香山站||雲梯||打火": filterAPIPoint + "filter?url=https%3A%2F%2Fwww.google.com.tw%2Falerts%2Ffeeds%2F04784784225885481651%2F11834919735038606131&include=" + includeText,
+				"Google 快訊 竹市 義消": filterAPIPoint + "filter?url=https%3A%2F%2Fwww.google.com.tw%2Falerts%2Ffeeds%2F04784784225885481651%2F18304303068024362009&include=" + includeText,
+				"Google 快訊 竹市 雲梯": filterAPIPoint + "filter?url=https%3A%2F%2Fwww.google.com.tw%2Falerts%2Ffeeds%2F04784784225885481651%2F10993434182923813560&include=" + includeText,
+				"指傳媒":             filterAPIPoint + "filter?url=http%3A%2F%2Fwww.fingermedia.tw%3Ffeed%3Drss2%26cat%3D2650&include=" + includeText,
+				"台灣好報 地方新聞":       filterAPIPoint + "filter?url=http%3A%2F%2Ffeeds.feedburner.com%2Fnewstaiwan&include=" + includeText,
+				"台灣新生報 地方綜合":      filterAPIPoint + "filter?url=http%3A%2F%2Ffeeds.feedburner.com%2Ftssdnews&include=" + includeText,
+				"天眼日報 警消新聞":       filterAPIPoint + "filter?url=http%3A%2F%2Ffeeds.feedburner.com%2Ftynews3&include=" + includeText,
+				//"新竹市政府": filterAPIPoint + "filter?url=http%3A%2F%2Fwww.hccg.gov.tw%2FMunicipalNews%3Flanguage%3Dchinese%26websitedn%3Dou%3Dhccg%2Cou%3Dap_root%2Co%3Dhccg%2Cc%3Dtw&include="+includeText,
+				"大成報":          filterAPIPoint + "filter?url=http%3A%2F%2Fwww.greatnews.com.tw%2Fhome%2Frss.php&include=" + includeText,
+				"聯合新聞網 地方桃竹苗版": filterAPIPoint + "filter?url=http%3A%2F%2Fudn.com%2Frssfeed%2Fnews%2F2%2F6641%2F7324%3Fch%3Dnews&include=" + includeText,
+				"中華新聞網":        filterAPIPoint + "filter?url=http%3A%2F%2Ffeeds.feedburner.com%2Fcdns&include=" + includeText,
+				"蕃新聞 社會":       filterAPIPoint + "filter?url=http%3A%2F%2Fn.yam.com%2FRSS%2FRss_society.xml&include=" + includeText,
+				"蕃新聞 地方":       filterAPIPoint + "filter?url=http%3A%2F%2Fn.yam.com%2FRSS%2FRss_place.xml&include=" + includeText,
+				"蘋果日報 要聞":      filterAPIPoint + "filter?url=http%3A%2F%2Fwww.appledaily.com.tw%2Frss%2Fcreate%2Fkind%2Fsec%2Ftype%2F11&include=" + includeText,
+				"自由時報社會版":      filterAPIPoint + "filter?url=http%3A%2F%2Fnews.ltn.com.tw%2Frss%2Fsociety.xml&include=" + includeText,
+				"聯合新聞網 即時 地方":  filterAPIPoint + "filter?url=http%3A%2F%2Fudn.com%2Frssfeed%2Fnews%2F1%2F3%3Fch%3Dnews&include=" + includeText,
+				"風傳媒 新竹頻道":     filterAPIPoint + "filter?url=http%3A%2F%2Fwww.storm.mg%2Ffeeds%2Fs36303&include=" + includeText,
+				"自由時報生活版":      filterAPIPoint + "filter?url=http%3A%2F%2Fnews.ltn.com.tw%2Frss%2Flife.xml&include=" + includeText,
+				"聯合新聞網 即時 社會":  filterAPIPoint + "filter?url=http%3A%2F%2Fudn.com%2Frssfeed%2Fnews%2F1%2F2%3Fch%3Dnews&include=" + includeText,
+				"台灣好新聞":        filterAPIPoint + "filter?url=https%3A%2F%2Fwww.google.com.tw%2Falerts%2Ffeeds%2F04784784225885481651%2F3504523367051993014&include=" + includeText,
+				"中時電子報 即時 社會":  filterAPIPoint + "filter?url=http%3A%2F%2Fwww.chinatimes.com%2Frss%2Frealtimenews-society.xml&include=" + includeText,
+				"聯合新聞網 地方":     filterAPIPoint + "filter?url=https%3A%2F%2Fudn.com%2Frssfeed%2Fnews%2F2%2F6641%3Fch%3Dnews&include=" + includeText,
+				"蘋果日報 即時":      filterAPIPoint + "filter?url=http%3A%2F%2Fwww.appledaily.com.tw%2Frss%2Fcreate%2Fkind%2Frnews%2Ftype%2Fnew&include=" + includeText,
+				"里長伯.tw":       filterAPIPoint + "filter?url=http%3A%2F%2Ffeeds.feedburner.com%2Flizhangbo&include=" + includeText,
+			}
+
+			return TopicResult{News: newsFetcher("hcfd", feeds, true)}
+		},
+	})
+
+	return topics
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"hash/fnv"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Typhoon warning kinds
+const (
+	TyphoonSea     = "sea"
+	TyphoonLand    = "land"
+	TyphoonSeaLand = "sea-land"
+	TyphoonLifted  = "lifted"
+)
+
+// Typhoon topic states
+const (
+	TopicIdle    = "idle"
+	TopicActive  = "active"
+	TopicUnknown = "unknown"
+)
+
+// TyphoonConfig struct
+type TyphoonConfig struct {
+	URL            string `json:"url"`
+	IdleInterval   int    `json:"idleInterval"`
+	ActiveInterval int    `json:"activeInterval"`
+}
+
+// TyphoonBulletin struct
+type TyphoonBulletin struct {
+	Identifier  string    `json:"id"`
+	Kind        string    `json:"kind"`
+	Headline    string    `json:"headline"`
+	Description string    `json:"description"`
+	Areas       []string  `json:"areas"`
+	Covered     bool      `json:"covered"`
+	Time        time.Time `json:"time"`
+	TimeText    string    `json:"timeText"`
+	Link        string    `json:"link"`
+}
+
+// ByBulletinTime implements sort.Interface for []TyphoonBulletin based on
+// the Time field.
+type ByBulletinTime []TyphoonBulletin
+
+func (a ByBulletinTime) Len() int           { return len(a) }
+func (a ByBulletinTime) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a ByBulletinTime) Less(i, j int) bool { return a[i].Time.After(a[j].Time) }
+
+// typhoonKind tells sea and land warnings apart from the CAP message
+func typhoonKind(v ResultCap) string {
+	switch {
+	case v.MsgType == "Cancel" || strings.Contains(v.Headline, "解除"):
+		return TyphoonLifted
+	case strings.Contains(v.Headline, "海上陸上"):
+		return TyphoonSeaLand
+	case strings.Contains(v.Headline, "陸上"):
+		return TyphoonLand
+	default:
+		return TyphoonSea
+	}
+}
+
+// LoadTyphoonBulletins loads CWB typhoon warning bulletins, newest first
+func LoadTyphoonBulletins(url string, j Jurisdiction) ([]TyphoonBulletin, error) {
+	bulletins := []TyphoonBulletin{}
+
+	entries, fetchErr := fetchCapEntries(url)
+	if fetchErr != nil {
+		return bulletins, fetchErr
+	}
+
+	for _, entry := range entries {
+		v := entry.Cap
+		if v.Event != "" && !strings.Contains(v.Event, "颱風") {
+			continue
+		}
+
+		timetext := v.Sent
+		if timetext == "" {
+			timetext = entry.Item.Published
+		}
+		local := loadLocal(timetext, "")
+
+		var areas []string
+		for _, area := range v.Area {
+			areas = append(areas, area.AreaDesc)
+		}
+
+		kind := typhoonKind(v)
+		covered := (kind == TyphoonLand || kind == TyphoonSeaLand) && len(j.MatchAreas(v.Area)) > 0
+
+		link := v.Web
+		if link == "" {
+			link = entry.Item.Link
+		}
+
+		bulletins = append(bulletins, TyphoonBulletin{
+			Identifier:  v.Identifier,
+			Kind:        kind,
+			Headline:    v.Headline,
+			Description: v.Description,
+			Areas:       areas,
+			Covered:     covered,
+			Time:        local,
+			TimeText:    local.Format("15:04"),
+			Link:        link,
+		})
+	}
+
+	sort.Sort(ByBulletinTime(bulletins))
+
+	return bulletins, nil
+}
+
+// TyphoonState returns whether a land warning currently covers the
+// jurisdiction, judged by the latest bulletin
+func TyphoonState(bulletins []TyphoonBulletin) string {
+	if len(bulletins) > 0 && bulletins[0].Covered {
+		return TopicActive
+	}
+	return TopicIdle
+}
+
+// TyphoonInterval returns the polling interval in seconds for a state,
+// an unknown state is retried as often as an active one
+func TyphoonInterval(state string, conf TyphoonConfig) int {
+	if state == TopicActive || state == TopicUnknown {
+		return conf.ActiveInterval
+	}
+	return conf.IdleInterval
+}
+
+// BulletinElements turns the bulletins of the current warning into
+// timeline entries
+func BulletinElements(bulletins []TyphoonBulletin) []RssItem {
+	collect := []RssItem{}
+	for _, bulletin := range bulletins {
+		if bulletin.Kind == TyphoonLifted {
+			break
+		}
+
+		h := fnv.New32a()
+		h.Write([]byte(bulletin.Identifier + bulletin.Headline))
+
+		collect = append(collect, RssItem{
+			Link:        bulletin.Link,
+			OriginLink:  bulletin.Link,
+			Time:        bulletin.Time,
+			TimeText:    bulletin.TimeText,
+			Title:       bulletin.Headline,
+			Source:      newsSource["cwb.gov.tw"],
+			Tag:         "颱風警報",
+			Status:      1,
+			Hash:        h.Sum32(),
+			Keyword:     "cwb.gov.tw",
+			Description: bulletin.Description,
+			Area:        strings.Join(bulletin.Areas, "、"),
+		})
+	}
+	return collect
+}
@@ -0,0 +1,371 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// Webhook payload formats
+const (
+	WebhookJSON  = "json"
+	WebhookSlack = "slack"
+	WebhookLINE  = "line"
+)
+
+const (
+	webhookMaxAttempts   = 5
+	webhookMaxDeliveries = 500
+	webhookNotifiedTTL   = 30 * 24 * time.Hour
+)
+
+var webhookTemplates = map[string]string{
+	WebhookSlack: "*[{{.Topic}}]* <{{.Link}}|{{.Item.Title}}>\n{{.Item.Source}} {{.Item.Tag}} {{.Item.TimeText}}",
+	WebhookLINE:  "\n[{{.Topic}}] {{.Item.Title}}\n{{.Item.Source}} {{.Item.TimeText}}\n{{.Link}}",
+}
+
+// WebhookConfig struct
+type WebhookConfig struct {
+	Name     string `json:"name"`
+	Topic    string `json:"topic"`
+	URL      string `json:"url"`
+	Format   string `json:"format"`
+	Template string `json:"template"`
+	Secret   string `json:"secret"`
+	Token    string `json:"token"`
+}
+
+// WebhookPayload is the generic JSON payload, also handed to templates
+type WebhookPayload struct {
+	Topic  string    `json:"topic"`
+	ID     string    `json:"id"`
+	Link   string    `json:"link"`
+	Item   RssItem   `json:"item"`
+	SentAt time.Time `json:"sentAt"`
+}
+
+// WebhookDelivery is one entry of the delivery log
+type WebhookDelivery struct {
+	Hook       string    `json:"hook"`
+	Topic      string    `json:"topic"`
+	ID         string    `json:"id"`
+	Title      string    `json:"title"`
+	Attempts   int       `json:"attempts"`
+	StatusCode int       `json:"statusCode"`
+	Error      string    `json:"error,omitempty"`
+	Delivered  bool      `json:"delivered"`
+	Time       time.Time `json:"time"`
+}
+
+type webhookState struct {
+	Notified   map[string]time.Time `json:"notified"`
+	Deliveries []WebhookDelivery    `json:"deliveries"`
+}
+
+// Notifier sends webhooks for new high-priority items, remembering what
+// was delivered in a state file so that restarts do not notify twice.
+// Items that failed are sent again on the next snapshot.
+type Notifier struct {
+	sync.Mutex
+	hooks      []WebhookConfig
+	path       string
+	state      webhookState
+	fresh      bool
+	seeded     map[string]bool
+	delivering map[string]bool
+	client     *http.Client
+}
+
+var notifier *Notifier
+
+// NewNotifier loads the delivery state kept at path
+func NewNotifier(hooks []WebhookConfig, path string) *Notifier {
+	n := &Notifier{
+		hooks: hooks,
+		path:  path,
+		state: webhookState{
+			Notified:   make(map[string]time.Time),
+			Deliveries: []WebhookDelivery{},
+		},
+		seeded:     make(map[string]bool),
+		delivering: make(map[string]bool),
+		client: &http.Client{
+			Timeout: time.Second * 10,
+		},
+	}
+
+	data, readErr := ioutil.ReadFile(path)
+	if readErr != nil {
+		if !os.IsNotExist(readErr) {
+			log.Printf("NewNotifier ioutil.ReadFile error: %v", readErr)
+		}
+		n.fresh = true
+		return n
+	}
+
+	if jsonErr := json.Unmarshal(data, &n.state); jsonErr != nil {
+		log.Printf("NewNotifier json.Unmarshal error: %v", jsonErr)
+	}
+	if n.state.Notified == nil {
+		n.state.Notified = make(map[string]time.Time)
+	}
+
+	return n
+}
+
+// key names a hook without giving away secrets carried in its url
+func (h WebhookConfig) key() string {
+	if h.Name != "" {
+		return h.Name
+	}
+	sum := sha256.Sum256([]byte(h.Topic + "|" + h.Format + "|" + h.URL))
+	host := h.URL
+	if u, parseErr := url.Parse(h.URL); parseErr == nil {
+		host = u.Host
+	}
+	return host + "#" + hex.EncodeToString(sum[:4])
+}
+
+// save writes the state file, the caller holds the lock
+func (n *Notifier) save() {
+	for key, t := range n.state.Notified {
+		if time.Since(t) > webhookNotifiedTTL {
+			delete(n.state.Notified, key)
+		}
+	}
+
+	data, jsonErr := json.Marshal(n.state)
+	if jsonErr != nil {
+		log.Printf("Notifier json.Marshal error: %v", jsonErr)
+		return
+	}
+
+	if mkdirErr := os.MkdirAll(filepath.Dir(n.path), 0755); mkdirErr != nil {
+		log.Printf("Notifier os.MkdirAll error: %v", mkdirErr)
+		return
+	}
+	tmp := n.path + ".tmp"
+	if writeErr := ioutil.WriteFile(tmp, data, 0600); writeErr != nil {
+		log.Printf("Notifier ioutil.WriteFile error: %v", writeErr)
+		return
+	}
+	if renameErr := os.Rename(tmp, n.path); renameErr != nil {
+		log.Printf("Notifier os.Rename error: %v", renameErr)
+	}
+}
+
+// Notify sends every Status == 1 item of a topic snapshot that was not
+// sent before. When there is no state file yet, the first snapshot of a
+// topic is only recorded so that deploying does not flood the hooks.
+func (n *Notifier) Notify(topic string, news []RssItem) {
+	if n == nil {
+		return
+	}
+
+	n.Lock()
+	defer n.Unlock()
+
+	seedOnly := n.fresh && !n.seeded[topic]
+	n.seeded[topic] = true
+
+	changed := false
+	for _, hook := range n.hooks {
+		if hook.Topic != topic {
+			continue
+		}
+		for _, item := range news {
+			if item.Status != 1 {
+				continue
+			}
+			key := hook.key() + "|" + itemID(item)
+			if _, found := n.state.Notified[key]; found || n.delivering[key] {
+				continue
+			}
+
+			if seedOnly {
+				n.state.Notified[key] = time.Now()
+				changed = true
+				continue
+			}
+			n.delivering[key] = true
+			go n.deliver(hook, topic, item, key)
+		}
+	}
+
+	if changed {
+		n.save()
+	}
+}
+
+// Deliveries returns the delivery log, newest first
+func (n *Notifier) Deliveries() []WebhookDelivery {
+	collect := []WebhookDelivery{}
+	if n == nil {
+		return collect
+	}
+
+	n.Lock()
+	defer n.Unlock()
+
+	for i := len(n.state.Deliveries) - 1; i >= 0; i-- {
+		collect = append(collect, n.state.Deliveries[i])
+	}
+	return collect
+}
+
+// record logs a delivery, the item only counts as notified once a hook
+// accepted it
+func (n *Notifier) record(delivery WebhookDelivery, key string) {
+	n.Lock()
+	defer n.Unlock()
+
+	delete(n.delivering, key)
+	if delivery.Delivered {
+		n.state.Notified[key] = time.Now()
+	}
+
+	n.state.Deliveries = append(n.state.Deliveries, delivery)
+	if len(n.state.Deliveries) > webhookMaxDeliveries {
+		n.state.Deliveries = n.state.Deliveries[len(n.state.Deliveries)-webhookMaxDeliveries:]
+	}
+	n.save()
+}
+
+// webhookSignature signs a body with HMAC-SHA256
+func webhookSignature(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func renderWebhookTemplate(text string, payload WebhookPayload) (string, error) {
+	tmpl, parseErr := template.New("webhook").Parse(text)
+	if parseErr != nil {
+		return "", parseErr
+	}
+	var buf bytes.Buffer
+	if execErr := tmpl.Execute(&buf, payload); execErr != nil {
+		return "", execErr
+	}
+	return buf.String(), nil
+}
+
+// webhookRequest builds the request for a hook format
+func webhookRequest(hook WebhookConfig, payload WebhookPayload) (*http.Request, error) {
+	var body []byte
+	contentType := "application/json"
+
+	text := hook.Template
+	if text == "" {
+		text = webhookTemplates[hook.Format]
+	}
+
+	switch hook.Format {
+	case WebhookSlack:
+		message, renderErr := renderWebhookTemplate(text, payload)
+		if renderErr != nil {
+			return nil, renderErr
+		}
+		var jsonErr error
+		body, jsonErr = json.Marshal(map[string]string{"text": message})
+		if jsonErr != nil {
+			return nil, jsonErr
+		}
+	case WebhookLINE:
+		message, renderErr := renderWebhookTemplate(text, payload)
+		if renderErr != nil {
+			return nil, renderErr
+		}
+		body = []byte(url.Values{"message": {message}}.Encode())
+		contentType = "application/x-www-form-urlencoded"
+	default:
+		var jsonErr error
+		body, jsonErr = json.Marshal(payload)
+		if jsonErr != nil {
+			return nil, jsonErr
+		}
+	}
+
+	req, reqErr := http.NewRequest("POST", hook.URL, bytes.NewReader(body))
+	if reqErr != nil {
+		return nil, reqErr
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("User-Agent", "firenews-webhook")
+	req.Header.Set("X-Firenews-Topic", payload.Topic)
+	req.Header.Set("X-Firenews-Delivery", payload.ID)
+	if hook.Secret != "" {
+		req.Header.Set("X-Firenews-Signature", webhookSignature(hook.Secret, body))
+	}
+	if hook.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+hook.Token)
+	}
+
+	return req, nil
+}
+
+// deliver posts one item, retrying with exponential backoff
+func (n *Notifier) deliver(hook WebhookConfig, topic string, item RssItem, key string) {
+	payload := WebhookPayload{
+		Topic:  topic,
+		ID:     itemID(item),
+		Link:   canonicalLink(item),
+		Item:   item,
+		SentAt: time.Now(),
+	}
+
+	delivery := WebhookDelivery{
+		Hook:  hook.key(),
+		Topic: topic,
+		ID:    payload.ID,
+		Title: item.Title,
+	}
+
+	backoff := time.Second
+	for delivery.Attempts < webhookMaxAttempts {
+		delivery.Attempts++
+		delivery.Time = time.Now()
+
+		req, reqErr := webhookRequest(hook, payload)
+		if reqErr != nil {
+			delivery.Error = reqErr.Error()
+			break
+		}
+
+		resp, doErr := n.client.Do(req)
+		if doErr == nil {
+			ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			delivery.StatusCode = resp.StatusCode
+			if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+				delivery.Delivered = true
+				delivery.Error = ""
+				break
+			}
+			delivery.Error = "unexpected status " + strconv.Itoa(resp.StatusCode)
+			if resp.StatusCode >= 400 && resp.StatusCode < 500 && resp.StatusCode != http.StatusTooManyRequests {
+				break
+			}
+		} else {
+			delivery.Error = doErr.Error()
+		}
+
+		log.Println("retry [", delivery.Attempts, "]:", delivery.Hook)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+
+	n.record(delivery, key)
+}
@@ -0,0 +1,546 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"hash"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mmcdole/gofeed"
+)
+
+// WebSub subscription states
+const (
+	WebSubDiscovering = "discovering"
+	WebSubNoHub       = "nohub"
+	WebSubPending     = "pending"
+	WebSubActive      = "active"
+	WebSubDenied      = "denied"
+	WebSubFailed      = "failed"
+)
+
+const (
+	defaultWebSubLease  = 5 * 24 * 3600
+	webSubCheckInterval = 5 * time.Minute
+	webSubRetryAfter    = time.Hour
+	webSubIdleAfter     = 24 * time.Hour
+	webSubMaxFeeds      = 500
+	webSubMaxBytes      = 5 << 20
+)
+
+var webSubHubErr = errors.New("hub refused the subscription")
+
+// WebSubConfig struct, Callback is the public url of
+// /api/websub/v1/callback and subscribing is off without it. PublicURL
+// is the public address of the server, such as
+// https://example.org/firenews, and the hub is off without it.
+type WebSubConfig struct {
+	Callback     string `json:"callback"`
+	PublicURL    string `json:"publicURL"`
+	LeaseSeconds int    `json:"leaseSeconds"`
+}
+
+// WebSubWatch is a tag of a topic fetching a feed. Include is the pattern
+// of the filter endpoint, matched against the entries, and Match the
+// include pattern of the source, matched against the items.
+type WebSubWatch struct {
+	Topic   string `json:"topic"`
+	Tag     string `json:"tag"`
+	Include string `json:"include"`
+	Match   string `json:"match,omitempty"`
+}
+
+// WebSubSubscription is a subscription to the hub of a feed
+type WebSubSubscription struct {
+	ID        string        `json:"id"`
+	Feed      string        `json:"feed"`
+	Topic     string        `json:"topic"`
+	Hub       string        `json:"hub"`
+	State     string        `json:"state"`
+	Reason    string        `json:"reason,omitempty"`
+	Requested time.Time     `json:"requested"`
+	Expires   time.Time     `json:"expires"`
+	LastPush  time.Time     `json:"lastPush"`
+	Pushes    int           `json:"pushes"`
+	Watched   time.Time     `json:"watched"`
+	Watches   []WebSubWatch `json:"watches"`
+	secret    string
+	newSecret string
+	local     bool
+}
+
+// WebSubSubscriber subscribes to the hubs of the feeds polled by topics
+// and merges the entries pushed by a hub into the topics watching them.
+// Feeds no topic fetched for a day are dropped.
+type WebSubSubscriber struct {
+	sync.Mutex
+	conf   WebSubConfig
+	topics *TopicRegistry
+	feeds  map[string]*WebSubSubscription
+	ids    map[string]*WebSubSubscription
+}
+
+var subscriber = NewWebSubSubscriber(WebSubConfig{}, nil)
+
+// NewWebSubSubscriber creates a subscriber
+func NewWebSubSubscriber(conf WebSubConfig, topics *TopicRegistry) *WebSubSubscriber {
+	if conf.LeaseSeconds <= 0 {
+		conf.LeaseSeconds = defaultWebSubLease
+	}
+	return &WebSubSubscriber{
+		conf:   conf,
+		topics: topics,
+		feeds:  make(map[string]*WebSubSubscription),
+		ids:    make(map[string]*WebSubSubscription),
+	}
+}
+
+// unwrapFilterURL returns the feed and include pattern behind a url of
+// the filter endpoint, or the url itself
+func unwrapFilterURL(rawURL string) (string, string) {
+	if internalPrefix == "" || !strings.HasPrefix(rawURL, internalPrefix+"filter?") {
+		return rawURL, ""
+	}
+	u, parseErr := url.Parse(rawURL)
+	if parseErr != nil {
+		return rawURL, ""
+	}
+	return u.Query().Get("url"), u.Query().Get("include")
+}
+
+func isLocalFeed(feed string) bool {
+	return !strings.HasPrefix(feed, "http://") && !strings.HasPrefix(feed, "https://")
+}
+
+// watchSource watches the feed of an RSS source fetched for a topic
+func watchSource(topic string, source Source) {
+	switch s := source.(type) {
+	case *RSSSource:
+		subscriber.Watch(topic, s.Tag, s.URL, "")
+	case includeSource:
+		if rss, ok := s.source.(*RSSSource); ok {
+			subscriber.Watch(topic, rss.Tag, rss.URL, s.rp.String())
+		}
+	}
+}
+
+// Watch registers a feed fetched for a tag of a topic, subscribing to
+// its hub the first time it is seen
+func (s *WebSubSubscriber) Watch(topic string, tag string, rawURL string, match string) {
+	if s.conf.Callback == "" {
+		return
+	}
+	feed, include := unwrapFilterURL(rawURL)
+	watch := WebSubWatch{Topic: topic, Tag: tag, Include: include, Match: match}
+
+	s.Lock()
+	defer s.Unlock()
+
+	if sub, found := s.feeds[feed]; found {
+		sub.Watched = time.Now()
+		for _, existing := range sub.Watches {
+			if existing == watch {
+				return
+			}
+		}
+		sub.Watches = append(sub.Watches, watch)
+		return
+	}
+	if len(s.feeds) >= webSubMaxFeeds {
+		return
+	}
+
+	sub := &WebSubSubscription{
+		ID:      newInternalKey()[:32],
+		Feed:    feed,
+		State:   WebSubDiscovering,
+		Watched: time.Now(),
+		Watches: []WebSubWatch{watch},
+		local:   isLocalFeed(feed),
+	}
+	s.feeds[feed] = sub
+	s.ids[sub.ID] = sub
+	go s.subscribe(sub)
+}
+
+// discoverHub reads the hub and self links of a feed
+func discoverHub(data []byte) (string, string) {
+	hub, self := "", ""
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	decoder.Strict = false
+	for {
+		token, tokenErr := decoder.Token()
+		if tokenErr != nil {
+			break
+		}
+		start, ok := token.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		if start.Name.Local == "item" || start.Name.Local == "entry" {
+			break
+		}
+		if start.Name.Local != "link" {
+			continue
+		}
+
+		rel, href := "", ""
+		for _, attr := range start.Attr {
+			switch attr.Name.Local {
+			case "rel":
+				rel = attr.Value
+			case "href":
+				href = strings.TrimSpace(attr.Value)
+			}
+		}
+		for _, r := range strings.Fields(rel) {
+			if r == "hub" && hub == "" {
+				hub = href
+			}
+			if r == "self" && self == "" {
+				self = href
+			}
+		}
+	}
+	return hub, self
+}
+
+func (s *WebSubSubscriber) setState(sub *WebSubSubscription, state string, reason string) {
+	s.Lock()
+	sub.State = state
+	sub.Reason = reason
+	s.Unlock()
+}
+
+// subscribe discovers the hub of a feed and asks it for a subscription.
+// Only local fixtures may name a local hub, remote hubs go through the
+// outbound url policy.
+func (s *WebSubSubscriber) subscribe(sub *WebSubSubscription) {
+	var data []byte
+	var fetchErr error
+	if sub.local {
+		data, fetchErr = fetchReport(sub.Feed)
+	} else {
+		data, fetchErr = egress.Fetch(sub.Feed, 30*time.Second)
+	}
+
+	s.Lock()
+	sub.Requested = time.Now()
+	s.Unlock()
+
+	if fetchErr != nil {
+		s.setState(sub, WebSubFailed, fetchErr.Error())
+		return
+	}
+
+	hub, self := discoverHub(data)
+	if hub == "" {
+		s.setState(sub, WebSubNoHub, "")
+		return
+	}
+	if self == "" {
+		self = sub.Feed
+	}
+
+	// the current secret stays valid until the hub verifies the new one
+	secret := newInternalKey()
+	s.Lock()
+	sub.Hub = hub
+	sub.Topic = self
+	sub.newSecret = secret
+	if sub.State != WebSubActive {
+		sub.State = WebSubPending
+	}
+	sub.Reason = ""
+	s.Unlock()
+
+	form := url.Values{
+		"hub.mode":          {"subscribe"},
+		"hub.topic":         {self},
+		"hub.callback":      {strings.TrimSuffix(s.conf.Callback, "/") + "/" + sub.ID},
+		"hub.lease_seconds": {strconv.Itoa(s.conf.LeaseSeconds)},
+		"hub.secret":        {secret},
+	}
+
+	var status int
+	var postErr error
+	if sub.local {
+		client := &http.Client{Timeout: 30 * time.Second}
+		resp, err := client.PostForm(hub, form)
+		if err == nil {
+			status = resp.StatusCode
+			resp.Body.Close()
+		}
+		postErr = err
+	} else {
+		status, postErr = egress.PostForm(hub, form, 30*time.Second)
+	}
+
+	switch {
+	case postErr != nil:
+		log.Printf("WebSubSubscriber subscribe error: %v %v", sub.Feed, postErr)
+		s.setState(sub, WebSubFailed, postErr.Error())
+	case status < 200 || status > 299:
+		log.Printf("WebSubSubscriber subscribe error: %v %v %d", sub.Feed, webSubHubErr, status)
+		s.setState(sub, WebSubFailed, webSubHubErr.Error()+": "+strconv.Itoa(status))
+	}
+}
+
+// validWebSubSignature checks an X-Hub-Signature header of the form
+// method=hex
+func validWebSubSignature(body []byte, header string, secret string) bool {
+	parts := strings.SplitN(header, "=", 2)
+	if len(parts) != 2 || secret == "" {
+		return false
+	}
+
+	var mac hash.Hash
+	switch parts[0] {
+	case "sha1":
+		mac = hmac.New(sha1.New, []byte(secret))
+	case "sha256":
+		mac = hmac.New(sha256.New, []byte(secret))
+	case "sha384":
+		mac = hmac.New(sha512.New384, []byte(secret))
+	case "sha512":
+		mac = hmac.New(sha512.New, []byte(secret))
+	default:
+		return false
+	}
+
+	expected, decodeErr := hex.DecodeString(parts[1])
+	if decodeErr != nil {
+		return false
+	}
+	mac.Write(body)
+	return hmac.Equal(mac.Sum(nil), expected)
+}
+
+// watchedItems returns the entries of a pushed feed a watch keeps, the
+// way the filter endpoint and the source would
+func watchedItems(watch WebSubWatch, feed *gofeed.Feed) []RssItem {
+	include, includeErr := regexp.Compile(watch.Include)
+	match, matchErr := regexp.Compile(watch.Match)
+	if includeErr != nil || matchErr != nil {
+		return nil
+	}
+
+	entries := *feed
+	entries.Items = nil
+	for _, item := range feed.Items {
+		if include.MatchString(CJKnorm(item.Title + item.Description + item.Content)) {
+			entries.Items = append(entries.Items, item)
+		}
+	}
+	if len(entries.Items) == 0 {
+		return nil
+	}
+
+	collect := []RssItem{}
+	for _, item := range feedItems(watch.Tag, &entries) {
+		if match.MatchString(CJKnorm(item.Title + item.Description)) {
+			collect = append(collect, item)
+		}
+	}
+	return collect
+}
+
+// Push handles content sent by a hub, merging the entries into the topics
+// watching the feed
+func (s *WebSubSubscriber) Push(id string, body []byte, signature string) (bool, error) {
+	s.Lock()
+	sub, found := s.ids[id]
+	var feedURL, secret, newSecret string
+	var watches []WebSubWatch
+	if found {
+		feedURL, secret, newSecret = sub.Feed, sub.secret, sub.newSecret
+		watches = append(watches, sub.Watches...)
+	}
+	s.Unlock()
+	if !found {
+		return false, nil
+	}
+
+	// content with a bad signature is ignored, yet acknowledged so that
+	// the hub does not keep sending it
+	if !validWebSubSignature(body, signature, secret) && !validWebSubSignature(body, signature, newSecret) {
+		log.Printf("WebSubSubscriber validWebSubSignature error: %v", feedURL)
+		return true, nil
+	}
+
+	feed, parserErr := gofeed.NewParser().Parse(bytes.NewReader(body))
+	if parserErr != nil {
+		return true, parserErr
+	}
+
+	s.Lock()
+	sub.LastPush = time.Now()
+	sub.Pushes++
+	s.Unlock()
+
+	if s.topics == nil {
+		return true, nil
+	}
+	for _, watch := range watches {
+		items := watchedItems(watch, feed)
+		if topic := s.topics.Get(watch.Topic); topic != nil && len(items) > 0 {
+			topic.Merge(items)
+		}
+	}
+	return true, nil
+}
+
+// Verify answers a verification of intent, returning false when the
+// request does not match a subscription
+func (s *WebSubSubscriber) Verify(id string, mode string, topic string, lease string, reason string) bool {
+	s.Lock()
+	defer s.Unlock()
+
+	sub, found := s.ids[id]
+	if !found || topic != sub.Topic {
+		return false
+	}
+
+	switch mode {
+	case "subscribe":
+		seconds, parseErr := strconv.Atoi(lease)
+		if parseErr != nil || seconds <= 0 {
+			seconds = s.conf.LeaseSeconds
+		}
+		sub.State = WebSubActive
+		sub.Reason = ""
+		if sub.newSecret != "" {
+			sub.secret, sub.newSecret = sub.newSecret, ""
+		}
+		sub.Expires = time.Now().Add(time.Duration(seconds) * time.Second)
+		return true
+	case "denied":
+		sub.State = WebSubDenied
+		sub.Reason = reason
+		return true
+	}
+	return false
+}
+
+// renew subscribes again before leases run out, retries failed
+// subscriptions now and then and drops the feeds no topic fetches any
+// more, their callbacks then answer gone to the hub
+func (s *WebSubSubscriber) renew() {
+	now := time.Now()
+	due := []*WebSubSubscription{}
+
+	s.Lock()
+	for feed, sub := range s.feeds {
+		if now.Sub(sub.Watched) > webSubIdleAfter {
+			delete(s.feeds, feed)
+			delete(s.ids, sub.ID)
+			continue
+		}
+		switch sub.State {
+		case WebSubActive:
+			before := time.Duration(s.conf.LeaseSeconds) * time.Second / 10
+			if before > time.Hour {
+				before = time.Hour
+			}
+			if sub.Expires.Sub(now) < before {
+				due = append(due, sub)
+			}
+		case WebSubPending, WebSubFailed, WebSubDenied, WebSubNoHub:
+			if now.Sub(sub.Requested) > webSubRetryAfter {
+				due = append(due, sub)
+			}
+		}
+	}
+	for _, sub := range due {
+		sub.Requested = now
+	}
+	s.Unlock()
+
+	for _, sub := range due {
+		go s.subscribe(sub)
+	}
+}
+
+// Subscriptions lists the subscriptions by feed
+func (s *WebSubSubscriber) Subscriptions() []WebSubSubscription {
+	s.Lock()
+	defer s.Unlock()
+
+	list := make([]WebSubSubscription, 0, len(s.feeds))
+	for _, sub := range s.feeds {
+		copied := *sub
+		copied.Watches = append([]WebSubWatch{}, sub.Watches...)
+		list = append(list, copied)
+	}
+	sort.Sort(byWebSubFeed(list))
+	return list
+}
+
+type byWebSubFeed []WebSubSubscription
+
+func (a byWebSubFeed) Len() int           { return len(a) }
+func (a byWebSubFeed) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a byWebSubFeed) Less(i, j int) bool { return a[i].Feed < a[j].Feed }
+
+// StartWebSub creates the subscriber and keeps its leases
+func StartWebSub(topics *TopicRegistry, conf WebSubConfig) {
+	subscriber = NewWebSubSubscriber(conf, topics)
+	if conf.Callback == "" {
+		return
+	}
+	go func() {
+		for range time.Tick(webSubCheckInterval) {
+			subscriber.renew()
+		}
+	}()
+}
+
+func webSubVerifyHandler(c *gin.Context) {
+	if !subscriber.Verify(c.Param("id"), c.Query("hub.mode"), c.Query("hub.topic"), c.Query("hub.lease_seconds"), c.Query("hub.reason")) {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "no such subscription",
+		})
+		return
+	}
+	c.String(http.StatusOK, "%s", c.Query("hub.challenge"))
+}
+
+func webSubPushHandler(c *gin.Context) {
+	body, readErr := ioutil.ReadAll(io.LimitReader(c.Request.Body, webSubMaxBytes))
+	if readErr != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": readErr.Error(),
+		})
+		return
+	}
+
+	found, pushErr := subscriber.Push(c.Param("id"), body, c.Request.Header.Get("X-Hub-Signature"))
+	if !found {
+		// gone makes the hub drop a subscription we no longer know
+		c.JSON(http.StatusGone, gin.H{
+			"error": "no such subscription",
+		})
+		return
+	}
+	if pushErr != nil {
+		log.Printf("webSubPushHandler subscriber.Push error: %v", pushErr)
+	}
+	c.Status(http.StatusAccepted)
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	webSubTestSelf = "https://news.example.org/feeds/latest.xml"
+	// the challenge is echoed as is, not read as a format
+	webSubTestChallenge = "challenge-%d-100%"
+)
+
+// fakeHub stands in for a WebSub hub: it takes subscriptions, verifies
+// the intent of the subscriber and pushes signed content
+type fakeHub struct {
+	sync.Mutex
+	callback string
+	topic    string
+	secret   string
+	verified chan bool
+}
+
+func (h *fakeHub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.FormValue("hub.mode") != "subscribe" {
+		http.Error(w, "unknown mode", http.StatusBadRequest)
+		return
+	}
+
+	h.Lock()
+	h.callback = r.FormValue("hub.callback")
+	h.topic = r.FormValue("hub.topic")
+	h.secret = r.FormValue("hub.secret")
+	h.Unlock()
+	w.WriteHeader(http.StatusAccepted)
+
+	go func() {
+		h.verified <- h.verify("subscribe", h.topic) == webSubTestChallenge
+	}()
+}
+
+// verify sends a verification of intent and returns the echoed challenge
+func (h *fakeHub) verify(mode string, topic string) string {
+	h.Lock()
+	callback := h.callback
+	h.Unlock()
+
+	resp, getErr := http.Get(callback + "?" + url.Values{
+		"hub.mode":          {mode},
+		"hub.topic":         {topic},
+		"hub.challenge":     {webSubTestChallenge},
+		"hub.lease_seconds": {"3600"},
+	}.Encode())
+	if getErr != nil {
+		return ""
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return ""
+	}
+	return string(body)
+}
+
+// push posts content to the subscriber, signed with secret
+func (h *fakeHub) push(callback string, secret string, body string) int {
+	req, _ := http.NewRequest("POST", callback, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/atom+xml")
+	req.Header.Set("Link", `<`+webSubTestSelf+`>; rel="self"`)
+	if secret != "" {
+		req.Header.Set("X-Hub-Signature", webhookSignature(secret, []byte(body)))
+	}
+	resp, postErr := http.DefaultClient.Do(req)
+	if postErr != nil {
+		return 0
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func webSubTestFeed(hub string, entries ...string) string {
+	var b bytes.Buffer
+	b.WriteString(`<?xml version="1.0" encoding="utf-8"?>
+<feed xmlns="http://www.w3.org/2005/Atom">
+  <title>news</title>
+  <id>` + webSubTestSelf + `</id>
+  <link rel="hub" href="` + hub + `"/>
+  <link rel="self" href="` + webSubTestSelf + `"/>
+  <updated>2018-02-06T23:50:00+08:00</updated>
+`)
+	for i, title := range entries {
+		n := string(rune('a' + i))
+		b.WriteString(`  <entry>
+    <title>` + title + `</title>
+    <id>` + webSubTestSelf + `#` + n + `</id>
+    <link href="https://news.example.org/` + n + `.html"/>
+    <published>` + time.Now().Format(time.RFC3339) + `</published>
+    <updated>` + time.Now().Format(time.RFC3339) + `</updated>
+  </entry>
+`)
+	}
+	b.WriteString(`</feed>`)
+	return b.String()
+}
+
+func TestWebSubSubscriber(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	dir, tempErr := ioutil.TempDir("", "websub")
+	if tempErr != nil {
+		t.Fatal(tempErr)
+	}
+	defer os.RemoveAll(dir)
+
+	savedSubscriber, savedArchive, savedPrefix := subscriber, archive, internalPrefix
+	defer func() {
+		subscriber, archive, internalPrefix = savedSubscriber, savedArchive, savedPrefix
+	}()
+	archive = NewArchive(dir)
+	internalPrefix = "http://localhost:1234/api/util/v1/"
+
+	hub := &fakeHub{verified: make(chan bool, 1)}
+	hubServer := httptest.NewServer(hub)
+	defer hubServer.Close()
+
+	router := gin.New()
+	router.GET("/api/websub/v1/callback/:id", webSubVerifyHandler)
+	router.POST("/api/websub/v1/callback/:id", webSubPushHandler)
+	callbackServer := httptest.NewServer(router)
+	defer callbackServer.Close()
+
+	feedPath := filepath.Join(dir, "latest.xml")
+	if writeErr := ioutil.WriteFile(feedPath, []byte(webSubTestFeed(hubServer.URL, "舊聞")), 0644); writeErr != nil {
+		t.Fatal(writeErr)
+	}
+
+	topics := NewTopicRegistry()
+	topic := &Topic{Name: "websub-test", Fetch: func() TopicResult { return TopicResult{} }}
+	topics.Add(topic)
+	snapshots.Set(topic.Name, TopicResult{News: []RssItem{{
+		Title: "舊聞",
+		Link:  "https://news.example.org/old.html",
+		Tag:   "即時",
+		Time:  time.Now().Add(-time.Hour),
+	}}})
+
+	subscriber = NewWebSubSubscriber(WebSubConfig{Callback: callbackServer.URL + "/api/websub/v1/callback"}, topics)
+	subscriber.Watch(topic.Name, "即時", internalPrefix+"filter?url="+url.QueryEscape(feedPath)+"&include=火", "")
+
+	select {
+	case ok := <-hub.verified:
+		if !ok {
+			t.Fatal("subscriber did not echo the challenge")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("hub got no subscription")
+	}
+
+	subs := subscriber.Subscriptions()
+	if len(subs) != 1 || subs[0].State != WebSubActive || subs[0].Topic != webSubTestSelf || subs[0].Hub != hubServer.URL {
+		t.Fatalf("subscriptions = %+v", subs)
+	}
+	if hub.topic != webSubTestSelf || !strings.HasPrefix(hub.callback, callbackServer.URL+"/api/websub/v1/callback/") {
+		t.Errorf("hub got topic %v and callback %v", hub.topic, hub.callback)
+	}
+
+	// intent for another topic is refused
+	if challenge := hub.verify("subscribe", "https://other.example.org/feed"); challenge != "" {
+		t.Errorf("foreign topic verified with %q", challenge)
+	}
+
+	// a badly signed push is acknowledged and ignored
+	body := webSubTestFeed(hubServer.URL, "竹北火警一人受傷", "天氣晴")
+	if status := hub.push(hub.callback, "not the secret", body); status != http.StatusAccepted {
+		t.Errorf("bad signature status = %d", status)
+	}
+	if snapshot, _ := snapshots.Get(topic.Name); len(snapshot.Result.News) != 1 {
+		t.Fatalf("badly signed push merged %d items", len(snapshot.Result.News)-1)
+	}
+
+	// a signed push merges the entries matching include
+	if status := hub.push(hub.callback, hub.secret, body); status != http.StatusAccepted {
+		t.Errorf("push status = %d", status)
+	}
+	snapshot, _ := snapshots.Get(topic.Name)
+	if len(snapshot.Result.News) != 2 {
+		t.Fatalf("snapshot = %+v", snapshot.Result.News)
+	}
+	var merged RssItem
+	for _, item := range snapshot.Result.News {
+		if item.Title == "竹北火警一人受傷" {
+			merged = item
+		}
+	}
+	if merged.Tag != "即時" || merged.OriginLink != "https://news.example.org/a.html" || merged.Time.IsZero() || merged.ID == "" {
+		t.Errorf("merged item = %+v", merged)
+	}
+
+	// gone tells the hub to drop subscriptions the server forgot
+	if status := hub.push(callbackServer.URL+"/api/websub/v1/callback/unknown", hub.secret, body); status != http.StatusGone {
+		t.Errorf("unknown subscription status = %d", status)
+	}
+}
+
+func TestValidWebSubSignature(t *testing.T) {
+	body := []byte("<feed/>")
+	if !validWebSubSignature(body, webhookSignature("secret", body), "secret") {
+		t.Error("sha256 signature refused")
+	}
+	mac := hmac.New(sha1.New, []byte("secret"))
+	mac.Write(body)
+	if !validWebSubSignature(body, "sha1="+hex.EncodeToString(mac.Sum(nil)), "secret") {
+		t.Error("sha1 signature refused")
+	}
+	for _, header := range []string{"", "sha256", "md5=00", "sha256=zz", webhookSignature("other", body)} {
+		if validWebSubSignature(body, header, "secret") {
+			t.Errorf("signature %q accepted", header)
+		}
+	}
+	if validWebSubSignature(body, webhookSignature("", body), "") {
+		t.Error("empty secret accepted")
+	}
+}
@@ -0,0 +1,588 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	webSubHubPath          = "/api/websub/v1/hub"
+	webSubTopicPath        = "/api/news/v1/"
+	webSubMinLease         = 3600
+	webSubMaxLease         = 30 * 24 * 3600
+	webSubMaxSecret        = 200
+	webSubMaxSubscriptions = 1000
+	webSubSeenTTL          = 30 * 24 * time.Hour
+)
+
+var webSubModeErr = errors.New("hub.mode must be subscribe or unsubscribe")
+var webSubTopicErr = errors.New("hub.topic must be the rss or atom feed of a topic of this server")
+var webSubCallbackErr = errors.New("hub.callback must be an http or https url")
+var webSubSecretErr = errors.New("hub.secret must be shorter than 200 bytes")
+var webSubFullErr = errors.New("the hub has too many subscriptions")
+var webSubChallengeErr = errors.New("callback did not echo the challenge")
+
+// WebSubHubSubscription is a subscriber of a topic feed. Seen holds the
+// items of the topic it was sent, or that it does not select, or that
+// were there before it subscribed.
+type WebSubHubSubscription struct {
+	ID           string               `json:"id"`
+	Topic        string               `json:"topic"`
+	Name         string               `json:"name"`
+	Callback     string               `json:"callback"`
+	Secret       string               `json:"secret,omitempty"`
+	Created      time.Time            `json:"created"`
+	Expires      time.Time            `json:"expires"`
+	Seeded       bool                 `json:"seeded"`
+	Seen         map[string]time.Time `json:"seen,omitempty"`
+	Delivered    int                  `json:"delivered"`
+	Failures     int                  `json:"failures"`
+	LastDelivery time.Time            `json:"lastDelivery"`
+	StatusCode   int                  `json:"statusCode"`
+	Error        string               `json:"error,omitempty"`
+	delivering   bool
+}
+
+type webSubHubState struct {
+	Subscriptions map[string]*WebSubHubSubscription `json:"subscriptions"`
+}
+
+// webSubHubTopic is a topic feed url, Query holds the filters of its
+// query string
+type webSubHubTopic struct {
+	Name   string
+	URL    string
+	Format string
+	Query  NewsQuery
+}
+
+// WebSubHub lets clients subscribe to the RSS and Atom feeds of topics
+// instead of polling them, and posts the items a topic gains to its
+// subscribers. Subscriptions and what they were sent are kept in a
+// state file.
+type WebSubHub struct {
+	sync.Mutex
+	conf   WebSubConfig
+	topics *TopicRegistry
+	path   string
+	state  webSubHubState
+}
+
+var websubHub *WebSubHub
+
+// NewWebSubHub loads the subscriptions kept at path
+func NewWebSubHub(conf WebSubConfig, topics *TopicRegistry, path string) *WebSubHub {
+	if conf.LeaseSeconds <= 0 {
+		conf.LeaseSeconds = defaultWebSubLease
+	}
+	h := &WebSubHub{
+		conf:   conf,
+		topics: topics,
+		path:   path,
+		state: webSubHubState{
+			Subscriptions: make(map[string]*WebSubHubSubscription),
+		},
+	}
+
+	data, readErr := ioutil.ReadFile(path)
+	if readErr != nil {
+		if !os.IsNotExist(readErr) {
+			log.Printf("NewWebSubHub ioutil.ReadFile error: %v", readErr)
+		}
+		return h
+	}
+
+	if jsonErr := json.Unmarshal(data, &h.state); jsonErr != nil {
+		log.Printf("NewWebSubHub json.Unmarshal error: %v", jsonErr)
+	}
+	if h.state.Subscriptions == nil {
+		h.state.Subscriptions = make(map[string]*WebSubHubSubscription)
+	}
+	for _, sub := range h.state.Subscriptions {
+		if sub.Seen == nil {
+			sub.Seen = make(map[string]time.Time)
+		}
+	}
+
+	return h
+}
+
+// StartWebSubHub creates the hub when the public address of the server
+// is known
+func StartWebSubHub(topics *TopicRegistry, conf WebSubConfig, path string) {
+	if conf.PublicURL == "" {
+		return
+	}
+	websubHub = NewWebSubHub(conf, topics, path)
+}
+
+// URL is the public url of the hub
+func (h *WebSubHub) URL() string {
+	return strings.TrimSuffix(h.conf.PublicURL, "/") + webSubHubPath
+}
+
+// topicURL reads the path and query of a topic feed. The canonical url
+// leaves the api key and paging out, so that every client of a feed
+// subscribes to the same topic url.
+func (h *WebSubHub) topicURL(path string, values url.Values) (webSubHubTopic, error) {
+	name := strings.TrimPrefix(path, webSubTopicPath)
+	if !strings.HasPrefix(path, webSubTopicPath) || strings.Contains(name, "/") || h.topics.Get(name) == nil {
+		return webSubHubTopic{}, webSubTopicErr
+	}
+	format := strings.ToLower(values.Get("format"))
+	if format != FormatRSS && format != FormatAtom {
+		return webSubHubTopic{}, webSubTopicErr
+	}
+
+	canonical := url.Values{}
+	for key, value := range values {
+		switch key {
+		case "api_key", "cursor", "limit":
+		default:
+			canonical[key] = value
+		}
+	}
+	canonical.Set("format", format)
+
+	query, queryErr := parseNewsValues(canonical)
+	if queryErr != nil {
+		return webSubHubTopic{}, queryErr
+	}
+
+	return webSubHubTopic{
+		Name:   name,
+		URL:    strings.TrimSuffix(h.conf.PublicURL, "/") + path + "?" + canonical.Encode(),
+		Format: format,
+		Query:  query,
+	}, nil
+}
+
+// hubTopic reads a topic url given by a subscriber
+func (h *WebSubHub) hubTopic(rawURL string) (webSubHubTopic, error) {
+	public, publicErr := url.Parse(strings.TrimSuffix(h.conf.PublicURL, "/"))
+	u, parseErr := url.Parse(rawURL)
+	if publicErr != nil || parseErr != nil || !strings.EqualFold(u.Host, public.Host) ||
+		!strings.HasPrefix(u.Path, public.Path+"/") {
+		return webSubHubTopic{}, webSubTopicErr
+	}
+	return h.topicURL(strings.TrimPrefix(u.Path, public.Path), u.Query())
+}
+
+func webSubHubID(topic string, callback string) string {
+	sum := sha256.Sum256([]byte(topic + "|" + callback))
+	return hex.EncodeToString(sum[:12])
+}
+
+func xmlEscape(text string) string {
+	var buf bytes.Buffer
+	xml.EscapeText(&buf, []byte(text))
+	return buf.String()
+}
+
+func webSubLinkHeader(hubURL string, self string) string {
+	return "<" + hubURL + `>; rel="hub", <` + self + `>; rel="self"`
+}
+
+// feedHubLinks puts the hub and self links at the top of a feed
+func feedHubLinks(feed string, format string, hubURL string, self string) string {
+	open, indent := "<channel>", "\n    "
+	link := `<atom:link xmlns:atom="http://www.w3.org/2005/Atom" rel="%s" href="%s"></atom:link>`
+	if format == FormatAtom {
+		open, indent = "<feed", "\n  "
+		link = `<link rel="%s" href="%s"></link>`
+	}
+
+	i := strings.Index(feed, open)
+	if i < 0 {
+		return feed
+	}
+	end := strings.Index(feed[i:], ">")
+	if end < 0 {
+		return feed
+	}
+	at := i + end + 1
+
+	links := indent + fmt.Sprintf(link, "hub", xmlEscape(hubURL)) +
+		indent + fmt.Sprintf(link, "self", xmlEscape(self))
+	return feed[:at] + links + feed[at:]
+}
+
+// advertise adds the hub and self links to the feed of a topic, in the
+// Link header and in the feed itself
+func (h *WebSubHub) advertise(c *gin.Context, format string, feed string) string {
+	if h == nil {
+		return feed
+	}
+	values := c.Request.URL.Query()
+	values.Set("format", format)
+	topic, topicErr := h.topicURL(c.Request.URL.Path, values)
+	if topicErr != nil {
+		return feed
+	}
+
+	c.Header("Link", webSubLinkHeader(h.URL(), topic.URL))
+	return feedHubLinks(feed, format, h.URL(), topic.URL)
+}
+
+// save writes the state file, the caller holds the lock
+func (h *WebSubHub) save() {
+	now := time.Now()
+	for id, sub := range h.state.Subscriptions {
+		if now.After(sub.Expires) {
+			delete(h.state.Subscriptions, id)
+			continue
+		}
+		for key, t := range sub.Seen {
+			if now.Sub(t) > webSubSeenTTL {
+				delete(sub.Seen, key)
+			}
+		}
+	}
+
+	data, jsonErr := json.Marshal(h.state)
+	if jsonErr != nil {
+		log.Printf("WebSubHub json.Marshal error: %v", jsonErr)
+		return
+	}
+
+	if mkdirErr := os.MkdirAll(filepath.Dir(h.path), 0755); mkdirErr != nil {
+		log.Printf("WebSubHub os.MkdirAll error: %v", mkdirErr)
+		return
+	}
+	tmp := h.path + ".tmp"
+	if writeErr := ioutil.WriteFile(tmp, data, 0600); writeErr != nil {
+		log.Printf("WebSubHub ioutil.WriteFile error: %v", writeErr)
+		return
+	}
+	if renameErr := os.Rename(tmp, h.path); renameErr != nil {
+		log.Printf("WebSubHub os.Rename error: %v", renameErr)
+	}
+}
+
+// seed marks the items of a topic as seen without sending them
+func (sub *WebSubHubSubscription) seed(news []RssItem, now time.Time) {
+	for _, item := range news {
+		sub.Seen[itemID(item)] = now
+	}
+	sub.Seeded = true
+}
+
+// verify asks the callback to confirm a subscription request, which only
+// takes effect once the callback echoes the challenge
+func (h *WebSubHub) verify(mode string, topic webSubHubTopic, callback string, secret string, lease int) {
+	u, parseErr := url.Parse(callback)
+	if parseErr != nil {
+		return
+	}
+	challenge := newInternalKey()
+	values := u.Query()
+	values.Set("hub.mode", mode)
+	values.Set("hub.topic", topic.URL)
+	values.Set("hub.challenge", challenge)
+	if mode == "subscribe" {
+		values.Set("hub.lease_seconds", strconv.Itoa(lease))
+	}
+	u.RawQuery = values.Encode()
+
+	status, body, sendErr := egress.Send("GET", u.String(), nil, nil, 30*time.Second)
+	if sendErr == nil && (status < 200 || status > 299 || strings.TrimSpace(string(body)) != challenge) {
+		sendErr = webSubChallengeErr
+	}
+	if sendErr != nil {
+		log.Printf("WebSubHub verify error: %v %v %v", mode, u.Host, sendErr)
+		return
+	}
+
+	id := webSubHubID(topic.URL, callback)
+	now := time.Now()
+
+	h.Lock()
+	defer h.Unlock()
+
+	if mode == "unsubscribe" {
+		delete(h.state.Subscriptions, id)
+		h.save()
+		return
+	}
+
+	sub, found := h.state.Subscriptions[id]
+	if !found {
+		if len(h.state.Subscriptions) >= webSubMaxSubscriptions {
+			log.Printf("WebSubHub verify error: %v %v", u.Host, webSubFullErr)
+			return
+		}
+		sub = &WebSubHubSubscription{
+			ID:       id,
+			Topic:    topic.URL,
+			Name:     topic.Name,
+			Callback: callback,
+			Created:  now,
+			Seen:     make(map[string]time.Time),
+		}
+		if snapshot, ok := snapshots.Get(topic.Name); ok {
+			sub.seed(snapshot.Result.News, now)
+		}
+		h.state.Subscriptions[id] = sub
+	}
+	sub.Secret = secret
+	sub.Expires = now.Add(time.Duration(lease) * time.Second)
+	h.save()
+}
+
+// Publish sends the items a topic snapshot gained to the subscribers of
+// the topic, each getting the ones its feed url selects
+func (h *WebSubHub) Publish(topic string, news []RssItem) {
+	if h == nil {
+		return
+	}
+
+	h.Lock()
+	defer h.Unlock()
+
+	now := time.Now()
+	changed := false
+	for id, sub := range h.state.Subscriptions {
+		if sub.Name != topic {
+			continue
+		}
+		if now.After(sub.Expires) {
+			delete(h.state.Subscriptions, id)
+			changed = true
+			continue
+		}
+		if !sub.Seeded {
+			sub.seed(news, now)
+			changed = true
+			continue
+		}
+		if sub.delivering {
+			continue
+		}
+		feed, topicErr := h.hubTopic(sub.Topic)
+		if topicErr != nil {
+			continue
+		}
+
+		fresh := []RssItem{}
+		for _, item := range news {
+			key := itemID(item)
+			if _, found := sub.Seen[key]; found {
+				continue
+			}
+			if !feed.Query.Match(item) {
+				sub.Seen[key] = now
+				changed = true
+				continue
+			}
+			fresh = append(fresh, item)
+		}
+		if len(fresh) == 0 {
+			continue
+		}
+
+		sub.delivering = true
+		go h.deliver(*sub, feed, fresh)
+	}
+
+	if changed {
+		h.save()
+	}
+}
+
+// deliver posts the items a topic gained to a subscriber, retrying with
+// exponential backoff. Items that could not be sent are sent again with
+// the next snapshot, a subscriber answering 410 Gone is dropped.
+func (h *WebSubHub) deliver(sub WebSubHubSubscription, topic webSubHubTopic, news []RssItem) {
+	var feed string
+	var renderErr error
+	contentType := "application/rss+xml; charset=utf-8"
+	if topic.Format == FormatAtom {
+		contentType = "application/atom+xml; charset=utf-8"
+		feed, renderErr = atomFeed(topic.Name, topic.URL, AttachStates(news))
+	} else {
+		feed, renderErr = rssFeed(topic.Name, topic.URL, AttachStates(news))
+	}
+
+	status := 0
+	var sendErr error
+	if renderErr != nil {
+		sendErr = renderErr
+	} else {
+		body := []byte(feedHubLinks(feed, topic.Format, h.URL(), topic.URL))
+		header := http.Header{
+			"Content-Type": {contentType},
+			"Link":         {webSubLinkHeader(h.URL(), topic.URL)},
+			"User-Agent":   {"firenews-websub"},
+		}
+		if sub.Secret != "" {
+			header.Set("X-Hub-Signature", webhookSignature(sub.Secret, body))
+		}
+
+		backoff := time.Second
+		for attempt := 1; attempt <= webhookMaxAttempts; attempt++ {
+			status, _, sendErr = egress.Send("POST", sub.Callback, header, body, 30*time.Second)
+			if sendErr == nil {
+				if status >= 200 && status < 300 {
+					break
+				}
+				sendErr = errors.New("unexpected status " + strconv.Itoa(status))
+				if status >= 400 && status < 500 && status != http.StatusTooManyRequests {
+					break
+				}
+			} else if _, ok := sendErr.(*EgressError); ok {
+				break
+			}
+			if attempt < webhookMaxAttempts {
+				time.Sleep(backoff)
+				backoff *= 2
+			}
+		}
+	}
+
+	h.Lock()
+	defer h.Unlock()
+
+	current, found := h.state.Subscriptions[sub.ID]
+	if !found {
+		return
+	}
+	current.delivering = false
+	current.LastDelivery = time.Now()
+	current.StatusCode = status
+
+	switch {
+	case status == http.StatusGone:
+		delete(h.state.Subscriptions, sub.ID)
+	case sendErr != nil:
+		log.Printf("WebSubHub deliver error: %v %v", sub.Name, sendErr)
+		current.Error = sendErr.Error()
+		current.Failures++
+	default:
+		current.Error = ""
+		current.Failures = 0
+		current.Delivered += len(news)
+		for _, item := range news {
+			current.Seen[itemID(item)] = current.LastDelivery
+		}
+	}
+	h.save()
+}
+
+// Subscriptions lists the subscribers of the hub by topic, without their
+// secrets
+func (h *WebSubHub) Subscriptions() []WebSubHubSubscription {
+	list := []WebSubHubSubscription{}
+	if h == nil {
+		return list
+	}
+
+	h.Lock()
+	defer h.Unlock()
+
+	for _, sub := range h.state.Subscriptions {
+		copied := *sub
+		copied.Secret = ""
+		copied.Seen = nil
+		list = append(list, copied)
+	}
+	sort.Sort(byWebSubHubTopic(list))
+	return list
+}
+
+type byWebSubHubTopic []WebSubHubSubscription
+
+func (a byWebSubHubTopic) Len() int      { return len(a) }
+func (a byWebSubHubTopic) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a byWebSubHubTopic) Less(i, j int) bool {
+	if a[i].Topic != a[j].Topic {
+		return a[i].Topic < a[j].Topic
+	}
+	return a[i].Callback < a[j].Callback
+}
+
+// webSubHubTopicName names the topic of a subscription request, so that
+// subscribing needs the right to read it
+func webSubHubTopicName(c *gin.Context) string {
+	if websubHub == nil {
+		return ""
+	}
+	topic, topicErr := websubHub.hubTopic(c.PostForm("hub.topic"))
+	if topicErr != nil {
+		return ""
+	}
+	return topic.Name
+}
+
+func webSubHubHandler(c *gin.Context) {
+	if websubHub == nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "the hub is off",
+		})
+		return
+	}
+
+	mode := c.PostForm("hub.mode")
+	if mode != "subscribe" && mode != "unsubscribe" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": webSubModeErr.Error(),
+		})
+		return
+	}
+
+	topic, topicErr := websubHub.hubTopic(c.PostForm("hub.topic"))
+	if topicErr != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": topicErr.Error(),
+		})
+		return
+	}
+
+	callback := c.PostForm("hub.callback")
+	u, parseErr := url.Parse(callback)
+	if parseErr != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": webSubCallbackErr.Error(),
+		})
+		return
+	}
+
+	secret := c.PostForm("hub.secret")
+	if len(secret) >= webSubMaxSecret {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": webSubSecretErr.Error(),
+		})
+		return
+	}
+
+	lease := websubHub.conf.LeaseSeconds
+	if seconds, leaseErr := strconv.Atoi(c.PostForm("hub.lease_seconds")); leaseErr == nil && seconds > 0 {
+		lease = seconds
+	}
+	if lease < webSubMinLease {
+		lease = webSubMinLease
+	}
+	if lease > webSubMaxLease {
+		lease = webSubMaxLease
+	}
+
+	go websubHub.verify(mode, topic, callback, secret, lease)
+	c.Status(http.StatusAccepted)
+}